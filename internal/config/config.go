@@ -14,8 +14,28 @@ const (
 	CRLF          = "\r\n"
 	WithScore     = "WITHSCORES"
 	BufferSize    = 1024
+	// MaxRandCount bounds the replies of SRANDMEMBER and ZRANDMEMBER
+	// with a negative count, which may repeat members
+	MaxRandCount = 1 << 20
 )
 
+// defaults for settings that may be left out of config.json
+func init() {
+	viper.SetDefault("set.maxIntsetEntries", 512)
+	viper.SetDefault("set.maxListpackEntries", 128)
+	viper.SetDefault("set.maxListpackValue", 64)
+	viper.SetDefault("zset.maxListpackEntries", 128)
+	viper.SetDefault("zset.maxListpackValue", 64)
+	viper.SetDefault("bf.errorRate", 0.01)
+	viper.SetDefault("bf.initialCapacity", 100)
+	viper.SetDefault("bf.expansion", 2)
+	viper.SetDefault("hll.sparseMaxBytes", 3000)
+	viper.SetDefault("expire.hz", 10)
+	viper.SetDefault("expire.cyclePercent", 25)
+	viper.SetDefault("expire.keysPerLoop", 20)
+	viper.SetDefault("expire.acceptableStalePercent", 10)
+}
+
 func SetConfigFile(path string) {
 	viper.SetConfigName("config")
 	viper.AddConfigPath(path)
@@ -33,3 +53,6 @@ func GetString(key string) string {
 func GetInt(key string) int {
 	return viper.GetInt(fmt.Sprint(key))
 }
+func GetFloat64(key string) float64 {
+	return viper.GetFloat64(fmt.Sprint(key))
+}
@@ -10,3 +10,72 @@ var ErrWrongType = errors.New(ERROR_WRONGTYPE)
 var ErrScoreIsNotFloat = errors.New(SCORE_MUST_BE_FLOAT)
 var ErrNoData = errors.New(NO_DATA)
 var ErrKeyNotExist = errors.New(KEY_NOT_EXIST)
+var ErrTimeoutNotFloat = errors.New(ERROR_TIMEOUT_NOT_FLOAT)
+var ErrTimeoutNegative = errors.New(ERROR_TIMEOUT_NEGATIVE)
+var ErrCrossSlot = errors.New(ERROR_CROSSSLOT)
+var ErrValueMustBePositive = errors.New(ERROR_VALUE_MUST_BE_POSITIVE)
+var ErrNumKeysNotPositive = errors.New(ERROR_NUMKEYS_NOT_POSITIVE)
+var ErrNumKeysTooMany = errors.New(ERROR_NUMKEYS_TOO_MANY)
+var ErrLimitNegative = errors.New(ERROR_LIMIT_NEGATIVE)
+var ErrMinMaxNotFloat = errors.New(ERROR_MIN_MAX_NOT_FLOAT)
+var ErrMinMaxNotStringRange = errors.New(ERROR_MIN_MAX_NOT_STRING_RANGE)
+var ErrLimitWithoutBy = errors.New(ERROR_LIMIT_WITHOUT_BY)
+var ErrWithScoresByLex = errors.New(ERROR_WITHSCORES_BYLEX)
+var ErrZAddXXAndNX = errors.New(ERROR_ZADD_XX_AND_NX)
+var ErrZAddGTLTNX = errors.New(ERROR_ZADD_GT_LT_NX)
+var ErrZAddIncrSinglePair = errors.New(ERROR_ZADD_INCR_SINGLE_PAIR)
+var ErrScoreNaN = errors.New(ERROR_SCORE_NAN)
+var ErrWeightNotFloat = errors.New(ERROR_WEIGHT_NOT_FLOAT)
+var ErrValueNotFloat = errors.New(ERROR_VALUE_NOT_FLOAT)
+var ErrGeoMemberNotFound = errors.New(ERROR_GEO_MEMBER_NOT_FOUND)
+var ErrGeoUnsupportedUnit = errors.New(ERROR_GEO_UNSUPPORTED_UNIT)
+var ErrGeoNeedFrom = errors.New(ERROR_GEO_NEED_FROM)
+var ErrGeoNeedBy = errors.New(ERROR_GEO_NEED_BY)
+var ErrGeoRadiusNegative = errors.New(ERROR_GEO_RADIUS_NEGATIVE)
+var ErrGeoBoxNegative = errors.New(ERROR_GEO_BOX_NEGATIVE)
+var ErrGeoCountNotPositive = errors.New(ERROR_GEO_COUNT_NOT_POSITIVE)
+var ErrGeoAnyWithoutCount = errors.New(ERROR_GEO_ANY_WITHOUT_COUNT)
+var ErrCMSDimensionsMismatch = errors.New(ERROR_CMS_DIMENSIONS_MISMATCH)
+var ErrCMSMergeOverflow = errors.New(ERROR_CMS_MERGE_OVERFLOW)
+var ErrCMSInvalidWindow = errors.New(ERROR_CMS_INVALID_WINDOW)
+var ErrCMSInvalidWidth = errors.New(ERROR_CMS_INVALID_WIDTH)
+var ErrCMSInvalidDepth = errors.New(ERROR_CMS_INVALID_DEPTH)
+var ErrCMSTooLarge = errors.New(ERROR_CMS_TOO_LARGE)
+var ErrTopKInvalidK = errors.New(ERROR_TOPK_INVALID_K)
+var ErrTopKInvalidWidth = errors.New(ERROR_TOPK_INVALID_WIDTH)
+var ErrTopKInvalidDepth = errors.New(ERROR_TOPK_INVALID_DEPTH)
+var ErrTopKInvalidDecay = errors.New(ERROR_TOPK_INVALID_DECAY)
+var ErrTopKInvalidIncrement = errors.New(ERROR_TOPK_INVALID_INCREMENT)
+var ErrTopKTooLarge = errors.New(ERROR_TOPK_TOO_LARGE)
+var ErrBFInvalidErrorRate = errors.New(ERROR_BF_INVALID_ERROR_RATE)
+var ErrBFInvalidCapacity = errors.New(ERROR_BF_INVALID_CAPACITY)
+var ErrBFInvalidExpansion = errors.New(ERROR_BF_INVALID_EXPANSION)
+var ErrBFNonScalingExpansion = errors.New(ERROR_BF_NONSCALING_EXPANSION)
+var ErrBFNonScalingFull = errors.New(ERROR_BF_NONSCALING_FULL)
+var ErrBFBadCapacity = errors.New(ERROR_BF_BAD_CAPACITY)
+var ErrBFMaxExpansion = errors.New(ERROR_BF_MAX_EXPANSION)
+var ErrBFExpansionTooLarge = errors.New(ERROR_BF_EXPANSION_TOO_LARGE)
+var ErrCFBadCapacity = errors.New(ERROR_CF_BAD_CAPACITY)
+var ErrCFBadBucketSize = errors.New(ERROR_CF_BAD_BUCKET_SIZE)
+var ErrCFBadMaxIterations = errors.New(ERROR_CF_BAD_MAX_ITERATIONS)
+var ErrCFBadExpansion = errors.New(ERROR_CF_BAD_EXPANSION)
+var ErrCFFilterFull = errors.New(ERROR_CF_FILTER_FULL)
+var ErrCFNotFound = errors.New(ERROR_CF_NOT_FOUND)
+var ErrCFTooLarge = errors.New(ERROR_CF_TOO_LARGE)
+var ErrHLLInvalid = errors.New(ERROR_HLL_INVALID)
+var ErrTDigestInvalidCompression = errors.New(ERROR_TDIGEST_INVALID_COMPRESSION)
+var ErrTDigestInvalidValue = errors.New(ERROR_TDIGEST_INVALID_VALUE)
+var ErrTDigestInvalidQuantile = errors.New(ERROR_TDIGEST_INVALID_QUANTILE)
+var ErrTDigestInvalidRank = errors.New(ERROR_TDIGEST_INVALID_RANK)
+var ErrTDigestInvalidCut = errors.New(ERROR_TDIGEST_INVALID_CUT)
+var ErrTDigestCutOrder = errors.New(ERROR_TDIGEST_CUT_ORDER)
+var ErrUnknownSubcommand = errors.New(ERROR_UNKNOWN_SUBCOMMAND)
+var ErrInvalidCursor = errors.New(ERROR_INVALID_CURSOR)
+var ErrStreamInvalidID = errors.New(ERROR_STREAM_INVALID_ID)
+var ErrStreamIDZero = errors.New(ERROR_STREAM_ID_ZERO)
+var ErrStreamIDTooSmall = errors.New(ERROR_STREAM_ID_TOO_SMALL)
+var ErrStreamNoGroup = errors.New(ERROR_STREAM_NO_GROUP)
+var ErrStreamBusyGroup = errors.New(ERROR_STREAM_BUSY_GROUP)
+var ErrStreamGroupNeedsKey = errors.New(ERROR_STREAM_GROUP_NEEDS_KEY)
+var ErrStreamLimitWithoutApprox = errors.New(ERROR_STREAM_LIMIT_WITHOUT_APPROX)
+var ErrStreamUnbalanced = errors.New(ERROR_STREAM_UNBALANCED)
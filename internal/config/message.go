@@ -2,6 +2,7 @@ package config
 
 var RespNil = []byte("$-1\r\n")
 var RespOk = []byte("+OK\r\n")
+var RespNilArray = []byte("*-1\r\n")
 
 const (
 	ERROR_WRONG_NUMBER_OF_ARGUMENTS         = "ERR wrong number of arguments for command"
@@ -12,4 +13,74 @@ const (
 	SCORE_MUST_BE_FLOAT                     = "Score must be floating point number"
 	NO_DATA                                 = "No Data"
 	KEY_NOT_EXIST                           = "key does not exist"
+	ERROR_TIMEOUT_NOT_FLOAT                 = "ERR timeout is not a float or out of range"
+	ERROR_TIMEOUT_NEGATIVE                  = "ERR timeout is negative"
+	ERROR_CROSSSLOT                         = "CROSSSLOT Keys in request don't hash to the same slot"
+	ERROR_VALUE_MUST_BE_POSITIVE            = "ERR value is out of range, must be positive"
+	ERROR_NUMKEYS_NOT_POSITIVE              = "ERR numkeys should be greater than 0"
+	ERROR_NUMKEYS_TOO_MANY                  = "ERR Number of keys can't be greater than number of args"
+	ERROR_LIMIT_NEGATIVE                    = "ERR LIMIT can't be negative"
+	ERROR_MIN_MAX_NOT_FLOAT                 = "ERR min or max is not a float"
+	ERROR_MIN_MAX_NOT_STRING_RANGE          = "ERR min or max not valid string range item"
+	ERROR_LIMIT_WITHOUT_BY                  = "ERR syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX"
+	ERROR_WITHSCORES_BYLEX                  = "ERR syntax error, WITHSCORES not supported in combination with BYLEX"
+	ERROR_ZADD_XX_AND_NX                    = "ERR XX and NX options at the same time are not compatible"
+	ERROR_ZADD_GT_LT_NX                     = "ERR GT, LT, and/or NX options at the same time are not compatible"
+	ERROR_ZADD_INCR_SINGLE_PAIR             = "ERR INCR option supports a single increment-element pair"
+	ERROR_SCORE_NAN                         = "ERR resulting score is not a number (NaN)"
+	ERROR_WEIGHT_NOT_FLOAT                  = "ERR weight value is not a float"
+	ERROR_VALUE_NOT_FLOAT                   = "ERR value is not a valid float"
+	ERROR_GEO_MEMBER_NOT_FOUND              = "ERR could not decode requested zset member"
+	ERROR_GEO_INVALID_LON_LAT               = "ERR invalid longitude,latitude pair %f,%f"
+	ERROR_GEO_UNSUPPORTED_UNIT              = "ERR unsupported unit provided. please use M, KM, FT, MI"
+	ERROR_GEO_NEED_FROM                     = "ERR exactly one of FROMMEMBER or FROMLONLAT can be specified for GEOSEARCH"
+	ERROR_GEO_NEED_BY                       = "ERR exactly one of BYRADIUS and BYBOX can be specified for GEOSEARCH"
+	ERROR_GEO_RADIUS_NEGATIVE               = "ERR radius cannot be negative"
+	ERROR_GEO_BOX_NEGATIVE                  = "ERR height or width cannot be negative"
+	ERROR_GEO_COUNT_NOT_POSITIVE            = "ERR COUNT must be > 0"
+	ERROR_GEO_ANY_WITHOUT_COUNT             = "ERR the ANY argument requires COUNT argument"
+	ERROR_CMS_DIMENSIONS_MISMATCH           = "CMS: width/depth is not equal"
+	ERROR_CMS_MERGE_OVERFLOW                = "CMS: MERGE overflow"
+	ERROR_CMS_INVALID_WINDOW                = "CMS: invalid window or granularity"
+	ERROR_CMS_INVALID_WIDTH                 = "CMS: invalid width"
+	ERROR_CMS_INVALID_DEPTH                 = "CMS: invalid depth"
+	ERROR_CMS_TOO_LARGE                     = "CMS: width x depth too large"
+	ERROR_TOPK_INVALID_K                    = "TopK: invalid k"
+	ERROR_TOPK_INVALID_WIDTH                = "TopK: invalid width"
+	ERROR_TOPK_INVALID_DEPTH                = "TopK: invalid depth"
+	ERROR_TOPK_INVALID_DECAY                = "TopK: invalid decay value. must be '<= 1' & '> 0'"
+	ERROR_TOPK_INVALID_INCREMENT            = "TopK: increment must be an integer greater than 0 and smaller or equal to 100000"
+	ERROR_TOPK_TOO_LARGE                    = "TopK: k or width x depth too large"
+	ERROR_BF_INVALID_ERROR_RATE             = "ERR (0 < error rate range < 1)"
+	ERROR_BF_INVALID_CAPACITY               = "ERR (capacity should be larger than 0)"
+	ERROR_BF_INVALID_EXPANSION              = "ERR expansion should be greater or equal to 1"
+	ERROR_BF_NONSCALING_EXPANSION           = "ERR Nonscaling filters cannot expand"
+	ERROR_BF_NONSCALING_FULL                = "ERR non scaling filter is full"
+	ERROR_BF_BAD_CAPACITY                   = "ERR filter too large for the capacity and error rate"
+	ERROR_BF_MAX_EXPANSION                  = "ERR Maximum expansion reached"
+	ERROR_BF_EXPANSION_TOO_LARGE            = "ERR expansion is too large"
+	ERROR_CF_BAD_CAPACITY                   = "ERR Bad capacity"
+	ERROR_CF_BAD_BUCKET_SIZE                = "ERR Bad bucket size"
+	ERROR_CF_BAD_MAX_ITERATIONS             = "ERR Bad max iterations"
+	ERROR_CF_BAD_EXPANSION                  = "ERR Bad expansion"
+	ERROR_CF_FILTER_FULL                    = "ERR Filter is full"
+	ERROR_CF_NOT_FOUND                      = "ERR Not found"
+	ERROR_CF_TOO_LARGE                      = "ERR filter too large for the capacity and bucket size"
+	ERROR_HLL_INVALID                       = "WRONGTYPE Key is not a valid HyperLogLog string value."
+	ERROR_TDIGEST_INVALID_COMPRESSION       = "ERR T-Digest: compression parameter needs to be a positive integer"
+	ERROR_TDIGEST_INVALID_VALUE             = "ERR T-Digest: error parsing val parameter"
+	ERROR_TDIGEST_INVALID_QUANTILE          = "ERR T-Digest: quantile should be in [0,1]"
+	ERROR_TDIGEST_INVALID_RANK              = "ERR T-Digest: rank needs to be non negative"
+	ERROR_TDIGEST_INVALID_CUT               = "ERR T-Digest: low_cut_percentile and high_cut_percentile should be in [0,1]"
+	ERROR_TDIGEST_CUT_ORDER                 = "ERR T-Digest: low_cut_percentile should be lower than high_cut_percentile"
+	ERROR_UNKNOWN_SUBCOMMAND                = "ERR unknown subcommand"
+	ERROR_INVALID_CURSOR                    = "ERR invalid cursor"
+	ERROR_STREAM_INVALID_ID                 = "ERR Invalid stream ID specified as stream command argument"
+	ERROR_STREAM_ID_ZERO                    = "ERR The ID specified in XADD must be greater than 0-0"
+	ERROR_STREAM_ID_TOO_SMALL               = "ERR The ID specified in XADD is equal or smaller than the target stream top item"
+	ERROR_STREAM_NO_GROUP                   = "NOGROUP No such key or consumer group"
+	ERROR_STREAM_BUSY_GROUP                 = "BUSYGROUP Consumer Group name already exists"
+	ERROR_STREAM_GROUP_NEEDS_KEY            = "ERR The XGROUP subcommand requires the key to exist. Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically."
+	ERROR_STREAM_LIMIT_WITHOUT_APPROX       = "ERR syntax error, LIMIT cannot be used without the special ~ option"
+	ERROR_STREAM_UNBALANCED                 = "ERR Unbalanced list of streams: for each stream key an ID must be specified."
 )
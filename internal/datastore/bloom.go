@@ -0,0 +1,235 @@
+package datastore
+
+import (
+	"backend/internal/config"
+	"math"
+
+	"github.com/spaolacci/murmur3"
+)
+
+// A scalable Bloom filter is a chain of plain Bloom filters. Items go into
+// the last one; once it holds its capacity a new one is appended, expansion
+// times larger and with a tighter error rate so that the error rate of the
+// whole chain stays within the requested one.
+
+// bloomTightening scales the error rate of each filter added to the chain.
+const bloomTightening = 0.5
+
+const (
+	// bloomMaxBits caps the size of a single filter in the chain, 512MB.
+	bloomMaxBits = 1 << 32
+
+	BloomMaxCapacity  = 1 << 40
+	BloomMaxExpansion = 32768
+)
+
+type bloomDefaults struct {
+	errorRate float64
+	capacity  uint64
+	expansion uint64
+}
+
+type bloomFilter struct {
+	bits     []uint64
+	nbits    uint64
+	hashes   uint64
+	capacity uint64
+	items    uint64
+}
+
+// newBloomFilter sizes a filter for capacity items at errorRate, or reports
+// false if it would take more than bloomMaxBits.
+func newBloomFilter(capacity uint64, errorRate float64) (*bloomFilter, bool) {
+	bitsPerItem := -math.Log(errorRate) / (math.Ln2 * math.Ln2)
+	size := math.Ceil(float64(capacity) * bitsPerItem)
+	if size > bloomMaxBits {
+		return nil, false
+	}
+	nbits := max(uint64(size), 64)
+	return &bloomFilter{
+		bits:     make([]uint64, (nbits+63)/64),
+		nbits:    nbits,
+		hashes:   uint64(math.Ceil(math.Ln2 * bitsPerItem)),
+		capacity: capacity,
+	}, true
+}
+
+// bloomHash hashes an item once for every filter; the bit positions are
+// derived from its two halves by double hashing.
+func bloomHash(item string) (uint64, uint64) {
+	return murmur3.Sum128([]byte(item))
+}
+
+func (f *bloomFilter) has(h1, h2 uint64) bool {
+	for i := uint64(0); i < f.hashes; i++ {
+		bit := (h1 + i*h2) % f.nbits
+		if f.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *bloomFilter) add(h1, h2 uint64) {
+	for i := uint64(0); i < f.hashes; i++ {
+		bit := (h1 + i*h2) % f.nbits
+		f.bits[bit/64] |= 1 << (bit % 64)
+	}
+	f.items++
+}
+
+type EntryBloom struct {
+	errorRate float64
+	// expansion is 0 for a filter that does not scale
+	expansion uint64
+	filters   []*bloomFilter
+}
+
+func CreateEntryBloom(errorRate float64, capacity, expansion uint64) (*EntryBloom, error) {
+	f, ok := newBloomFilter(capacity, errorRate*bloomTightening)
+	if !ok {
+		return nil, config.ErrBFBadCapacity
+	}
+	return &EntryBloom{
+		errorRate: errorRate,
+		expansion: expansion,
+		filters:   []*bloomFilter{f},
+	}, nil
+}
+
+func (b *EntryBloom) has(h1, h2 uint64) bool {
+	for _, f := range b.filters {
+		if f.has(h1, h2) {
+			return true
+		}
+	}
+	return false
+}
+
+// add inserts item and reports whether it was new.
+func (b *EntryBloom) add(item string) (bool, error) {
+	h1, h2 := bloomHash(item)
+	if b.has(h1, h2) {
+		return false, nil
+	}
+
+	last := b.filters[len(b.filters)-1]
+	if last.items >= last.capacity {
+		if b.expansion == 0 {
+			return false, config.ErrBFNonScalingFull
+		}
+		errorRate := b.errorRate * math.Pow(bloomTightening, float64(len(b.filters)+1))
+		capacity := last.capacity * b.expansion
+		if capacity/b.expansion != last.capacity {
+			return false, config.ErrBFMaxExpansion
+		}
+		var ok bool
+		if last, ok = newBloomFilter(capacity, errorRate); !ok {
+			return false, config.ErrBFMaxExpansion
+		}
+		b.filters = append(b.filters, last)
+	}
+	last.add(h1, h2)
+	return true, nil
+}
+
+func (s *Datastore) getBloom(key string) (*EntryBloom, bool, error) {
+	e, ok := s.getEntry(key)
+	if !ok {
+		return nil, false, nil
+	}
+	b, ok := e.val.(*EntryBloom)
+	if !ok {
+		return nil, false, config.ErrWrongType
+	}
+	return b, true, nil
+}
+
+// BF.RESERVE
+// An expansion of 0 makes the filter non-scaling.
+func (s *Datastore) CreateBloom(key string, errorRate float64, capacity, expansion uint64) (bool, error) {
+	if e, ok := s.getEntry(key); ok {
+		if _, ok := e.val.(*EntryBloom); ok {
+			return false, nil
+		}
+		return false, config.ErrWrongType
+	}
+	b, err := CreateEntryBloom(errorRate, capacity, expansion)
+	if err != nil {
+		return false, err
+	}
+	s.m[key] = Entry{val: b}
+	return true, nil
+}
+
+// BF.MADD
+// A missing filter is created with the configured defaults. added[i] reports
+// whether items[i] was new; adding stops at the first error.
+func (s *Datastore) BloomAdd(key string, items []string) ([]bool, error) {
+	b, ok, err := s.getBloom(key)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		d := s.bloomDefaults
+		if b, err = CreateEntryBloom(d.errorRate, d.capacity, d.expansion); err != nil {
+			return nil, err
+		}
+		s.m[key] = Entry{val: b}
+	}
+
+	added := make([]bool, 0, len(items))
+	for _, item := range items {
+		ok, err := b.add(item)
+		if err != nil {
+			return added, err
+		}
+		added = append(added, ok)
+	}
+	return added, nil
+}
+
+// BF.MEXISTS
+// Items are never in a missing filter.
+func (s *Datastore) BloomExists(key string, items []string) ([]bool, error) {
+	b, ok, err := s.getBloom(key)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]bool, len(items))
+	if !ok {
+		return res, nil
+	}
+	for i, item := range items {
+		res[i] = b.has(bloomHash(item))
+	}
+	return res, nil
+}
+
+type BloomInfo struct {
+	Capacity  uint64
+	Size      uint64
+	Filters   int
+	Items     uint64
+	Expansion uint64
+}
+
+// BF.INFO
+func (s *Datastore) BloomInfo(key string) (BloomInfo, error) {
+	b, ok, err := s.getBloom(key)
+	if err != nil {
+		return BloomInfo{}, err
+	}
+	if !ok {
+		return BloomInfo{}, config.ErrKeyNotExist
+	}
+
+	info := BloomInfo{Filters: len(b.filters), Expansion: b.expansion}
+	for _, f := range b.filters {
+		info.Capacity += f.capacity
+		info.Size += uint64(len(f.bits)) * 8
+		info.Items += f.items
+	}
+	return info, nil
+}
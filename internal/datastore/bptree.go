@@ -4,12 +4,20 @@ import (
 	"sort"
 )
 
-// A simplified in-memory B+ tree for (score, member) keys.
-// Keys are (score float64, member string) and order is by score, then member lexicographically.
+// A simplified in-memory B+ tree, generic over its key type.
+// Sorted sets key it by (score float64, member string), ordered by score, then member lexicographically;
+// streams key it by entry ID.
 // Internal nodes store separator keys and child pointers; leaves store actual entries and are linked.
+// A separator is the smallest key of the child to its right.
 
 const bptOrder = 64
 
+// bptItem is the ordering a key type must provide to be stored in a bptree.
+type bptItem[K any] interface {
+	less(other K) bool
+	equal(other K) bool
+}
+
 type bptKey struct {
 	score  float64
 	member string
@@ -30,67 +38,59 @@ type bptNode interface {
 	isLeaf() bool
 }
 
-type bptLeaf struct {
-	keys   []bptKey
-	next   *bptLeaf
-	prev   *bptLeaf
-	parent *bptInternal
+type bptLeaf[K bptItem[K]] struct {
+	keys   []K
+	next   *bptLeaf[K]
+	prev   *bptLeaf[K]
+	parent *bptInternal[K]
 }
 
-type bptInternal struct {
-	sep    []bptKey  // separator keys (len = children-1)
+type bptInternal[K bptItem[K]] struct {
+	sep    []K       // separator keys (len = children-1)
 	child  []bptNode // child pointers
 	counts []int     // subtree counts per child (same len as child)
-	parent *bptInternal
+	parent *bptInternal[K]
 }
 
-func (l *bptLeaf) isLeaf() bool     { return true }
-func (n *bptInternal) isLeaf() bool { return false }
+func (l *bptLeaf[K]) isLeaf() bool     { return true }
+func (n *bptInternal[K]) isLeaf() bool { return false }
 
 // new tree: root is leaf
-type bptree struct {
+type bptree[K bptItem[K]] struct {
 	root bptNode
 	size int
 }
 
-func newBPTree() *bptree {
-	return &bptree{
-		root: &bptLeaf{keys: make([]bptKey, 0)},
+func newBPTree[K bptItem[K]]() *bptree[K] {
+	return &bptree[K]{
+		root: &bptLeaf[K]{keys: make([]K, 0)},
 		size: 0,
 	}
 }
 
 // findLeaf returns leaf node and index where key should be inserted or exists.
-func (t *bptree) findLeaf(key bptKey) (*bptLeaf, int) {
+func (t *bptree[K]) findLeaf(key K) (*bptLeaf[K], int) {
 	n := t.root
 	for {
 		if n.isLeaf() {
-			l := n.(*bptLeaf)
+			l := n.(*bptLeaf[K])
 			// binary search by key
 			idx := sort.Search(len(l.keys), func(i int) bool {
 				return !l.keys[i].less(key)
 			})
 			return l, idx
 		}
-		in := n.(*bptInternal)
-		// choose child by separators
+		in := n.(*bptInternal[K])
+		// choose child by separators: child i holds keys in [sep[i-1], sep[i])
 		idx := sort.Search(len(in.sep), func(i int) bool {
-			return !in.sep[i].less(key)
+			return key.less(in.sep[i])
 		})
-		// if idx == len(sep) -> choose last child
-		if idx == len(in.sep) {
-			// check last separator
-			idx = len(in.child) - 1
-		} else {
-			// need to determine which child; sep[i] >= key => child i
-			// standard B+ internal semantics: child index = idx
-		}
 		n = in.child[idx]
 	}
 }
 
 // insert key into leaf at index idx (idx may be len(keys) meaning append)
-func (t *bptree) insert(key bptKey) int {
+func (t *bptree[K]) insert(key K) int {
 	leaf, idx := t.findLeaf(key)
 	// If exact equal, replace (duplicate member+score shouldn't happen since member unique; but we will allow update through higher-level)
 	if idx < len(leaf.keys) && leaf.keys[idx].equal(key) {
@@ -98,7 +98,8 @@ func (t *bptree) insert(key bptKey) int {
 		return 0
 	}
 	// insert into slice
-	leaf.keys = append(leaf.keys, bptKey{})  // grow
+	var zero K
+	leaf.keys = append(leaf.keys, zero)      // grow
 	copy(leaf.keys[idx+1:], leaf.keys[idx:]) // shift right
 	leaf.keys[idx] = key
 	t.size++
@@ -112,9 +113,9 @@ func (t *bptree) insert(key bptKey) int {
 	return 1
 }
 
-func (t *bptree) incrementCounts(n bptNode, delta int) {
+func (t *bptree[K]) incrementCounts(n bptNode, delta int) {
 	switch n := n.(type) {
-	case *bptLeaf:
+	case *bptLeaf[K]:
 		var node bptNode = n
 		p := n.parent
 		for p != nil {
@@ -130,7 +131,7 @@ func (t *bptree) incrementCounts(n bptNode, delta int) {
 			node = p
 			p = p.parent
 		}
-	case *bptInternal:
+	case *bptInternal[K]:
 		// shouldn't usually call with internal, but we can ascend
 		var node bptNode = n
 		p := n.parent
@@ -149,10 +150,10 @@ func (t *bptree) incrementCounts(n bptNode, delta int) {
 	}
 }
 
-func (t *bptree) splitLeaf(l *bptLeaf) {
+func (t *bptree[K]) splitLeaf(l *bptLeaf[K]) {
 	mid := len(l.keys) / 2
-	newLeaf := &bptLeaf{
-		keys:   append([]bptKey(nil), l.keys[mid:]...),
+	newLeaf := &bptLeaf[K]{
+		keys:   append([]K(nil), l.keys[mid:]...),
 		next:   l.next,
 		prev:   l,
 		parent: l.parent,
@@ -166,8 +167,8 @@ func (t *bptree) splitLeaf(l *bptLeaf) {
 	// attach into parent
 	if l.parent == nil {
 		// new root
-		newRoot := &bptInternal{
-			sep:    []bptKey{newLeaf.keys[0]},
+		newRoot := &bptInternal[K]{
+			sep:    []K{newLeaf.keys[0]},
 			child:  []bptNode{l, newLeaf},
 			counts: []int{len(l.keys), len(newLeaf.keys)},
 		}
@@ -192,7 +193,8 @@ func (t *bptree) splitLeaf(l *bptLeaf) {
 	copy(p.child[insertIdx+1:], p.child[insertIdx:])
 	p.child[insertIdx+1] = newLeaf
 
-	p.sep = append(p.sep, bptKey{})
+	var zero K
+	p.sep = append(p.sep, zero)
 	copy(p.sep[insertIdx+1:], p.sep[insertIdx:])
 	p.sep[insertIdx] = newLeaf.keys[0]
 
@@ -205,7 +207,7 @@ func (t *bptree) splitLeaf(l *bptLeaf) {
 	t.fixUpAfterInsert(p)
 }
 
-func (t *bptree) fixUpAfterInsert(p *bptInternal) {
+func (t *bptree[K]) fixUpAfterInsert(p *bptInternal[K]) {
 	if len(p.child) > bptOrder {
 		// Split internal node.
 		mid := len(p.child) / 2
@@ -218,8 +220,8 @@ func (t *bptree) fixUpAfterInsert(p *bptInternal) {
 		leftChild := append([]bptNode(nil), p.child[:mid]...)
 		rightChild := append([]bptNode(nil), p.child[mid:]...)
 
-		leftSep := append([]bptKey(nil), p.sep[:mid-1]...) // before promoted
-		rightSep := append([]bptKey(nil), p.sep[mid:]...)  // after promoted
+		leftSep := append([]K(nil), p.sep[:mid-1]...) // before promoted
+		rightSep := append([]K(nil), p.sep[mid:]...)  // after promoted
 
 		leftCounts := append([]int(nil), p.counts[:mid]...)
 		rightCounts := append([]int(nil), p.counts[mid:]...)
@@ -229,7 +231,7 @@ func (t *bptree) fixUpAfterInsert(p *bptInternal) {
 		p.sep = leftSep
 		p.counts = leftCounts
 
-		right := &bptInternal{
+		right := &bptInternal[K]{
 			sep:    rightSep,
 			child:  rightChild,
 			counts: rightCounts,
@@ -237,18 +239,18 @@ func (t *bptree) fixUpAfterInsert(p *bptInternal) {
 		}
 		for _, c := range right.child {
 			if c.isLeaf() {
-				c.(*bptLeaf).parent = right
+				c.(*bptLeaf[K]).parent = right
 			} else {
-				c.(*bptInternal).parent = right
+				c.(*bptInternal[K]).parent = right
 			}
 		}
 
 		if p.parent == nil {
 			// new root
-			newRoot := &bptInternal{
-				sep:    []bptKey{promoted},
+			newRoot := &bptInternal[K]{
+				sep:    []K{promoted},
 				child:  []bptNode{p, right},
-				counts: []int{sumCounts(p), sumCounts(right)},
+				counts: []int{sumCounts[K](p), sumCounts[K](right)},
 			}
 			p.parent = newRoot
 			right.parent = newRoot
@@ -266,14 +268,15 @@ func (t *bptree) fixUpAfterInsert(p *bptInternal) {
 		copy(par.child[idx+1:], par.child[idx:])
 		par.child[idx+1] = right
 
-		par.sep = append(par.sep, bptKey{})
+		var zero K
+		par.sep = append(par.sep, zero)
 		copy(par.sep[idx+1:], par.sep[idx:])
 		par.sep[idx] = promoted
 
 		par.counts = append(par.counts, 0)
 		copy(par.counts[idx+1:], par.counts[idx:])
-		par.counts[idx] = sumCounts(p)
-		par.counts[idx+1] = sumCounts(right)
+		par.counts[idx] = sumCounts[K](p)
+		par.counts[idx+1] = sumCounts[K](right)
 
 		right.parent = par
 		t.fixUpAfterInsert(par)
@@ -282,38 +285,38 @@ func (t *bptree) fixUpAfterInsert(p *bptInternal) {
 		cur := p
 		for cur != nil {
 			for i := range cur.counts {
-				cur.counts[i] = nodeCount(cur.child[i])
+				cur.counts[i] = nodeCount[K](cur.child[i])
 			}
 			cur = cur.parent
 		}
 	}
 }
 
-func sumCounts(n bptNode) int {
+func sumCounts[K bptItem[K]](n bptNode) int {
 	// total items in node subtree
 	if n.isLeaf() {
-		return len(n.(*bptLeaf).keys)
+		return len(n.(*bptLeaf[K]).keys)
 	}
 	sum := 0
-	for _, c := range n.(*bptInternal).child {
-		sum += nodeCount(c)
+	for _, c := range n.(*bptInternal[K]).child {
+		sum += nodeCount[K](c)
 	}
 	return sum
 }
 
-func nodeCount(n bptNode) int {
+func nodeCount[K bptItem[K]](n bptNode) int {
 	if n.isLeaf() {
-		return len(n.(*bptLeaf).keys)
+		return len(n.(*bptLeaf[K]).keys)
 	}
 	total := 0
-	for _, c := range n.(*bptInternal).child {
-		total += nodeCount(c)
+	for _, c := range n.(*bptInternal[K]).child {
+		total += nodeCount[K](c)
 	}
 	return total
 }
 
 // delete a (score, member) key from tree; returns true if removed
-func (t *bptree) delete(key bptKey) bool {
+func (t *bptree[K]) delete(key K) bool {
 	leaf, idx := t.findLeaf(key)
 	if idx >= len(leaf.keys) || !leaf.keys[idx].equal(key) {
 		return false
@@ -322,27 +325,178 @@ func (t *bptree) delete(key bptKey) bool {
 	leaf.keys = append(leaf.keys[:idx], leaf.keys[idx+1:]...)
 	t.size--
 	t.incrementCounts(leaf, -1)
-	// merging/borrowing omitted for simplicity (can be added). We keep nodes under-full tolerant.
+	t.rebalanceLeaf(leaf)
 	return true
 }
 
+// deleteRange removes the keys ranked in [lo, hi) and returns them. Each
+// leaf's share of the run is cut out at once rather than key by key.
+func (t *bptree[K]) deleteRange(lo, hi int) []K {
+	lo, hi = max(lo, 0), min(hi, t.size)
+	if lo >= hi {
+		return nil
+	}
+
+	removed := make([]K, 0, hi-lo)
+	for need := hi - lo; need > 0; {
+		// rebalancing reshapes the leaves, so look the next run up again
+		leaf, idx := t.leafAtRank(lo)
+		n := min(len(leaf.keys)-idx, need)
+		removed = append(removed, leaf.keys[idx:idx+n]...)
+		leaf.keys = append(leaf.keys[:idx], leaf.keys[idx+n:]...)
+		t.size -= n
+		t.incrementCounts(leaf, -n)
+		t.rebalanceLeaf(leaf)
+		need -= n
+	}
+	return removed
+}
+
+// Nodes other than the root may not drop below these fill levels. Merging an
+// underfull node into a sibling that cannot lend stays under the split limits.
+const (
+	bptMinLeafKeys = bptOrder/2 - 1
+	bptMinChildren = bptOrder / 2
+)
+
+func childIndex[K bptItem[K]](p *bptInternal[K], n bptNode) int {
+	for i, c := range p.child {
+		if c == n {
+			return i
+		}
+	}
+	return -1
+}
+
+// rebalanceLeaf restores the fill level of l after deletions. It pairs l with
+// a sibling and shares their keys evenly between them or, when together they
+// are too few for two leaves, merges them into one.
+func (t *bptree[K]) rebalanceLeaf(l *bptLeaf[K]) {
+	p := l.parent
+	if p == nil || len(l.keys) >= bptMinLeafKeys {
+		return
+	}
+	i := childIndex[K](p, l)
+	if i > 0 {
+		i--
+	}
+	left, right := p.child[i].(*bptLeaf[K]), p.child[i+1].(*bptLeaf[K])
+
+	total := len(left.keys) + len(right.keys)
+	if total >= 2*bptMinLeafKeys {
+		keys := append(append(make([]K, 0, total), left.keys...), right.keys...)
+		mid := total / 2
+		left.keys = append(left.keys[:0], keys[:mid]...)
+		right.keys = append(right.keys[:0], keys[mid:]...)
+		p.sep[i] = right.keys[0]
+		p.counts[i], p.counts[i+1] = len(left.keys), len(right.keys)
+		return
+	}
+
+	left.keys = append(left.keys, right.keys...)
+	left.next = right.next
+	if right.next != nil {
+		right.next.prev = left
+	}
+	t.removeChild(p, i)
+}
+
+// removeChild drops child i+1 of p after its contents went into child i.
+func (t *bptree[K]) removeChild(p *bptInternal[K], i int) {
+	p.counts[i] += p.counts[i+1]
+	p.child = append(p.child[:i+1], p.child[i+2:]...)
+	p.sep = append(p.sep[:i], p.sep[i+1:]...)
+	p.counts = append(p.counts[:i+1], p.counts[i+2:]...)
+	t.rebalanceInternal(p)
+}
+
+// rebalanceInternal is rebalanceLeaf for internal nodes, moving children
+// along with the separators between them. A root left with a single child
+// is replaced by that child.
+func (t *bptree[K]) rebalanceInternal(n *bptInternal[K]) {
+	p := n.parent
+	if p == nil {
+		if len(n.child) == 1 {
+			t.root = n.child[0]
+			setParent[K](t.root, nil)
+		}
+		return
+	}
+	if len(n.child) >= bptMinChildren {
+		return
+	}
+	i := childIndex[K](p, n)
+
+	if i > 0 {
+		left := p.child[i-1].(*bptInternal[K])
+		if len(left.child) > bptMinChildren {
+			last := len(left.child) - 1
+			c, cnt := left.child[last], left.counts[last]
+			n.child = append([]bptNode{c}, n.child...)
+			n.counts = append([]int{cnt}, n.counts...)
+			n.sep = append([]K{p.sep[i-1]}, n.sep...)
+			p.sep[i-1] = left.sep[last-1]
+			left.child, left.counts, left.sep = left.child[:last], left.counts[:last], left.sep[:last-1]
+			setParent[K](c, n)
+			p.counts[i-1] -= cnt
+			p.counts[i] += cnt
+			return
+		}
+	}
+	if i < len(p.child)-1 {
+		right := p.child[i+1].(*bptInternal[K])
+		if len(right.child) > bptMinChildren {
+			c, cnt := right.child[0], right.counts[0]
+			n.child = append(n.child, c)
+			n.counts = append(n.counts, cnt)
+			n.sep = append(n.sep, p.sep[i])
+			p.sep[i] = right.sep[0]
+			right.child, right.counts, right.sep = right.child[1:], right.counts[1:], right.sep[1:]
+			setParent[K](c, n)
+			p.counts[i] += cnt
+			p.counts[i+1] -= cnt
+			return
+		}
+	}
+
+	if i == len(p.child)-1 {
+		i--
+	}
+	left, right := p.child[i].(*bptInternal[K]), p.child[i+1].(*bptInternal[K])
+	left.sep = append(append(left.sep, p.sep[i]), right.sep...)
+	left.child = append(left.child, right.child...)
+	left.counts = append(left.counts, right.counts...)
+	for _, c := range right.child {
+		setParent[K](c, left)
+	}
+	t.removeChild(p, i)
+}
+
+func setParent[K bptItem[K]](n bptNode, p *bptInternal[K]) {
+	if n.isLeaf() {
+		n.(*bptLeaf[K]).parent = p
+	} else {
+		n.(*bptInternal[K]).parent = p
+	}
+}
+
 // rank returns 0-based rank of key if present, or -1
-func (t *bptree) rankOf(key bptKey) int {
+func (t *bptree[K]) rankOf(key K) int {
 	// walk down, accumulate counts of left siblings
 	var rank int
 	n := t.root
 	for {
 		if n.isLeaf() {
-			l := n.(*bptLeaf)
+			l := n.(*bptLeaf[K])
 			idx := sort.Search(len(l.keys), func(i int) bool { return !l.keys[i].less(key) })
 			if idx < len(l.keys) && l.keys[idx].equal(key) {
 				return rank + idx
 			}
 			return -1
 		}
-		in := n.(*bptInternal)
+		in := n.(*bptInternal[K])
 		// choose child and accumulate counts of previous children
-		idx := sort.Search(len(in.sep), func(i int) bool { return !in.sep[i].less(key) })
+		idx := sort.Search(len(in.sep), func(i int) bool { return key.less(in.sep[i]) })
 		// idx is child index
 		// add counts of children before idx
 		for i := 0; i < idx; i++ {
@@ -352,7 +506,48 @@ func (t *bptree) rankOf(key bptKey) int {
 	}
 }
 
-func (t *bptree) rangeByRank(start, stop int) []bptKey {
+// countBefore returns the rank of the first key for which atOrAfter reports
+// true, i.e. how many keys precede it. atOrAfter must be false for a prefix of
+// the keys and true for the rest, which lets it steer the descent like a key.
+func (t *bptree[K]) countBefore(atOrAfter func(K) bool) int {
+	var rank int
+	n := t.root
+	for !n.isLeaf() {
+		in := n.(*bptInternal[K])
+		idx := sort.Search(len(in.sep), func(i int) bool { return atOrAfter(in.sep[i]) })
+		for i := 0; i < idx; i++ {
+			rank += in.counts[i]
+		}
+		n = in.child[idx]
+	}
+	l := n.(*bptLeaf[K])
+	return rank + sort.Search(len(l.keys), func(i int) bool { return atOrAfter(l.keys[i]) })
+}
+
+// leafAtRank returns the leaf holding the key of the given rank and its index there.
+func (t *bptree[K]) leafAtRank(rank int) (*bptLeaf[K], int) {
+	idx := rank
+	node := t.root
+
+	// descend to leaf containing rank
+	for !node.isLeaf() {
+		in := node.(*bptInternal[K])
+		cum := 0
+		childIdx := 0
+		for i := 0; i < len(in.child); i++ {
+			if cum+in.counts[i] > idx {
+				childIdx = i
+				break
+			}
+			cum += in.counts[i]
+		}
+		idx -= cum
+		node = in.child[childIdx]
+	}
+	return node.(*bptLeaf[K]), idx
+}
+
+func (t *bptree[K]) rangeByRank(start, stop int) []K {
 	if t == nil || t.size == 0 || t.root == nil {
 		return nil
 	}
@@ -376,27 +571,8 @@ func (t *bptree) rangeByRank(start, stop int) []bptKey {
 	}
 
 	need := stop - start + 1
-	idx := start
-	node := t.root
-
-	// descend to leaf containing start
-	for !node.isLeaf() {
-		in := node.(*bptInternal)
-		cum := 0
-		childIdx := 0
-		for i := 0; i < len(in.child); i++ {
-			if cum+in.counts[i] > idx {
-				childIdx = i
-				break
-			}
-			cum += in.counts[i]
-		}
-		idx -= cum
-		node = in.child[childIdx]
-	}
-
-	leaf := node.(*bptLeaf)
-	result := make([]bptKey, 0, need)
+	leaf, idx := t.leafAtRank(start)
+	result := make([]K, 0, need)
 
 	for curr := leaf; curr != nil && len(result) < need; curr = curr.next {
 		for ; idx < len(curr.keys) && len(result) < need; idx++ {
@@ -408,7 +584,7 @@ func (t *bptree) rangeByRank(start, stop int) []bptKey {
 	return result
 }
 
-func (t *bptree) rangeByRankDesc(start, stop int) []bptKey {
+func (t *bptree[K]) rangeByRankDesc(start, stop int) []K {
 	if t == nil || t.size == 0 || t.root == nil {
 		return nil
 	}
@@ -435,10 +611,10 @@ func (t *bptree) rangeByRankDesc(start, stop int) []bptKey {
 
 	node := t.root
 	for !node.isLeaf() {
-		in := node.(*bptInternal)
+		in := node.(*bptInternal[K])
 		node = in.child[len(in.child)-1]
 	}
-	curr := node.(*bptLeaf)
+	curr := node.(*bptLeaf[K])
 
 	accum := 0 // number of elements we've "skipped" so far from the rightmost side
 	for curr != nil {
@@ -447,7 +623,7 @@ func (t *bptree) rangeByRankDesc(start, stop int) []bptKey {
 			offsetFromRight := start - accum
 			idx := cnt - 1 - offsetFromRight
 
-			result := make([]bptKey, 0, need)
+			result := make([]K, 0, need)
 			c := curr
 			i := idx
 			for c != nil && len(result) < need {
@@ -467,3 +643,35 @@ func (t *bptree) rangeByRankDesc(start, stop int) []bptKey {
 
 	return nil
 }
+
+// ascend calls fn for every key >= from in ascending order until fn returns false.
+func (t *bptree[K]) ascend(from K, fn func(K) bool) {
+	leaf, idx := t.findLeaf(from)
+	for curr := leaf; curr != nil; curr = curr.next {
+		for ; idx < len(curr.keys); idx++ {
+			if !fn(curr.keys[idx]) {
+				return
+			}
+		}
+		idx = 0
+	}
+}
+
+// descend calls fn for every key <= from in descending order until fn returns false.
+func (t *bptree[K]) descend(from K, fn func(K) bool) {
+	leaf, idx := t.findLeaf(from)
+	if idx == len(leaf.keys) || !leaf.keys[idx].equal(from) {
+		idx--
+	}
+	for curr := leaf; curr != nil; {
+		for ; idx >= 0; idx-- {
+			if !fn(curr.keys[idx]) {
+				return
+			}
+		}
+		curr = curr.prev
+		if curr != nil {
+			idx = len(curr.keys) - 1
+		}
+	}
+}
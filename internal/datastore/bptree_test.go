@@ -0,0 +1,225 @@
+package datastore
+
+import (
+	"math/rand"
+	"sort"
+	"testing"
+	"testing/quick"
+)
+
+type testKey int
+
+func (k testKey) less(other testKey) bool  { return k < other }
+func (k testKey) equal(other testKey) bool { return k == other }
+
+// checkTree verifies the structural invariants of t and that it holds
+// exactly want, in order.
+func checkTree(t *testing.T, tree *bptree[testKey], want []testKey) {
+	t.Helper()
+
+	if tree.size != len(want) {
+		t.Fatalf("size %d, want %d", tree.size, len(want))
+	}
+
+	leafDepth := -1
+	var leaves []*bptLeaf[testKey]
+	var walk func(n bptNode, parent *bptInternal[testKey], depth int, lo, hi *testKey) int
+	walk = func(n bptNode, parent *bptInternal[testKey], depth int, lo, hi *testKey) int {
+		switch n := n.(type) {
+		case *bptLeaf[testKey]:
+			if n.parent != parent {
+				t.Fatalf("leaf has wrong parent")
+			}
+			if parent != nil && len(n.keys) < bptMinLeafKeys {
+				t.Fatalf("leaf underfull: %d keys", len(n.keys))
+			}
+			if len(n.keys) >= bptOrder {
+				t.Fatalf("leaf overfull: %d keys", len(n.keys))
+			}
+			if leafDepth == -1 {
+				leafDepth = depth
+			} else if depth != leafDepth {
+				t.Fatalf("leaves at depths %d and %d", leafDepth, depth)
+			}
+			for i, k := range n.keys {
+				if (lo != nil && k < *lo) || (hi != nil && k >= *hi) {
+					t.Fatalf("key %d outside separator bounds", k)
+				}
+				if i > 0 && !n.keys[i-1].less(k) {
+					t.Fatalf("leaf keys out of order")
+				}
+			}
+			leaves = append(leaves, n)
+			return len(n.keys)
+		case *bptInternal[testKey]:
+			if n.parent != parent {
+				t.Fatalf("internal node has wrong parent")
+			}
+			if parent == nil && len(n.child) < 2 {
+				t.Fatalf("internal root with %d children", len(n.child))
+			}
+			if parent != nil && len(n.child) < bptMinChildren {
+				t.Fatalf("internal node underfull: %d children", len(n.child))
+			}
+			if len(n.child) > bptOrder {
+				t.Fatalf("internal node overfull: %d children", len(n.child))
+			}
+			if len(n.sep) != len(n.child)-1 || len(n.counts) != len(n.child) {
+				t.Fatalf("%d children, %d separators, %d counts", len(n.child), len(n.sep), len(n.counts))
+			}
+			total := 0
+			for i, c := range n.child {
+				clo, chi := lo, hi
+				if i > 0 {
+					clo = &n.sep[i-1]
+				}
+				if i < len(n.sep) {
+					chi = &n.sep[i]
+				}
+				cnt := walk(c, n, depth+1, clo, chi)
+				if n.counts[i] != cnt {
+					t.Fatalf("counts[%d] = %d, subtree holds %d", i, n.counts[i], cnt)
+				}
+				total += cnt
+			}
+			return total
+		}
+		t.Fatalf("unknown node type %T", n)
+		return 0
+	}
+	walk(tree.root, nil, 0, nil, nil)
+
+	var got []testKey
+	for i, l := range leaves {
+		if i > 0 && l.prev != leaves[i-1] {
+			t.Fatalf("leaf %d has wrong prev link", i)
+		}
+		if i < len(leaves)-1 && l.next != leaves[i+1] {
+			t.Fatalf("leaf %d has wrong next link", i)
+		}
+		got = append(got, l.keys...)
+	}
+	if leaves[0].prev != nil || leaves[len(leaves)-1].next != nil {
+		t.Fatalf("leaf chain is not terminated")
+	}
+	if len(got) != len(want) {
+		t.Fatalf("leaves hold %d keys, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("key %d is %d, want %d", i, got[i], want[i])
+		}
+	}
+}
+
+// model is the sorted reference a tree is compared against.
+type model map[testKey]bool
+
+func (m model) sorted() []testKey {
+	keys := make([]testKey, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	return keys
+}
+
+func checkRanks(t *testing.T, tree *bptree[testKey], want []testKey, rnd *rand.Rand) {
+	t.Helper()
+
+	for i := 0; i < 20 && len(want) > 0; i++ {
+		r := rnd.Intn(len(want))
+		if got := tree.rankOf(want[r]); got != r {
+			t.Fatalf("rankOf(%d) = %d, want %d", want[r], got, r)
+		}
+		keys := tree.rangeByRank(r, r+10)
+		for j, k := range keys {
+			if k != want[r+j] {
+				t.Fatalf("rangeByRank(%d) [%d] = %d, want %d", r, j, k, want[r+j])
+			}
+		}
+	}
+}
+
+// TestBPTreeRandomOps runs random inserts and deletes against a model,
+// weighted to grow the tree to several levels and then shrink it back.
+func TestBPTreeRandomOps(t *testing.T) {
+	prop := func(seed int64) bool {
+		rnd := rand.New(rand.NewSource(seed))
+		tree := newBPTree[testKey]()
+		m := model{}
+		keySpace := 1 + rnd.Intn(20000)
+
+		for phase := 0; phase < 2; phase++ {
+			insertBias := 0.8
+			if phase == 1 {
+				insertBias = 0.2
+			}
+			for i := 0; i < 10000; i++ {
+				k := testKey(rnd.Intn(keySpace))
+				if rnd.Float64() < insertBias {
+					added := tree.insert(k) == 1
+					if added == m[k] {
+						t.Fatalf("insert(%d) added=%v with key present=%v", k, added, m[k])
+					}
+					m[k] = true
+				} else {
+					if tree.delete(k) != m[k] {
+						t.Fatalf("delete(%d) disagrees with model", k)
+					}
+					delete(m, k)
+				}
+			}
+			want := m.sorted()
+			checkTree(t, tree, want)
+			checkRanks(t, tree, want, rnd)
+		}
+
+		for _, k := range m.sorted() {
+			tree.delete(k)
+		}
+		checkTree(t, tree, nil)
+		return true
+	}
+
+	if err := quick.Check(prop, &quick.Config{MaxCount: 20}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestBPTreeDeleteRange removes random rank ranges until the tree is empty.
+func TestBPTreeDeleteRange(t *testing.T) {
+	prop := func(seed int64) bool {
+		rnd := rand.New(rand.NewSource(seed))
+		tree := newBPTree[testKey]()
+		m := model{}
+		for i := rnd.Intn(30000); i > 0; i-- {
+			k := testKey(rnd.Int())
+			tree.insert(k)
+			m[k] = true
+		}
+
+		want := m.sorted()
+		for len(want) > 0 {
+			lo := rnd.Intn(len(want))
+			hi := lo + rnd.Intn(min(len(want)-lo, 2000)) + 1
+			removed := tree.deleteRange(lo, hi)
+			for i, k := range removed {
+				if k != want[lo+i] {
+					t.Fatalf("deleteRange(%d, %d) removed %d at %d, want %d", lo, hi, k, i, want[lo+i])
+				}
+			}
+			if len(removed) != hi-lo {
+				t.Fatalf("deleteRange(%d, %d) removed %d keys", lo, hi, len(removed))
+			}
+			want = append(want[:lo], want[hi:]...)
+			checkTree(t, tree, want)
+			checkRanks(t, tree, want, rnd)
+		}
+		return true
+	}
+
+	if err := quick.Check(prop, &quick.Config{MaxCount: 10}); err != nil {
+		t.Fatal(err)
+	}
+}
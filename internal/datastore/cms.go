@@ -2,24 +2,58 @@ package datastore
 
 import (
 	"backend/internal/config"
-	"errors"
 	"math"
 	"sync/atomic"
+	"time"
 
 	"github.com/spaolacci/murmur3"
 )
 
+// CMSOptions are the modes a sketch is created with.
+type CMSOptions struct {
+	// Conservative raises counters only as far as the item's new estimate,
+	// which keeps the estimates of colliding items lower.
+	Conservative bool
+	// Counter64 uses 64-bit counters instead of 32-bit ones.
+	Counter64 bool
+}
+
+// cmsMaxBytes caps the counters of a sketch, the sub-sketches of a windowed
+// one included, 512MB.
+const cmsMaxBytes = 1 << 29
+
+// cmsBytes returns the size of the counters of a w by d sketch.
+func cmsBytes(w, d float64, opts CMSOptions) float64 {
+	if opts.Counter64 {
+		return w * d * 8
+	}
+	return w * d * 4
+}
+
 type EntryCMS struct {
-	width   uint32
-	depth   uint32
-	counter [][]uint32
+	width uint32
+	depth uint32
+	opts  CMSOptions
+	// one of counter and counter64 is used, as set by opts.Counter64
+	counter   [][]uint32
+	counter64 [][]uint64
+	// count is the total of all increments.
+	count uint64
 }
 
 // CreateEntryCMS initializes a new Count-Min Sketch with given width and depth.
-func CreateEntryCMS(w uint32, d uint32) *EntryCMS {
+func CreateEntryCMS(w uint32, d uint32, opts CMSOptions) *EntryCMS {
 	e := &EntryCMS{
 		width: w,
 		depth: d,
+		opts:  opts,
+	}
+	if opts.Counter64 {
+		e.counter64 = make([][]uint64, d)
+		for i := uint32(0); i < d; i++ {
+			e.counter64[i] = make([]uint64, w)
+		}
+		return e
 	}
 	e.counter = make([][]uint32, d)
 	for i := uint32(0); i < d; i++ {
@@ -38,135 +72,293 @@ func calcHash(item string, seed uint32) uint32 {
 	return murmur3.Sum32WithSeed([]byte(item), seed)
 }
 
-func (s *Datastore) getCMS(key string) (*EntryCMS, error) {
+// maxCount is the value counters saturate at.
+func (cms *EntryCMS) maxCount() uint64 {
+	if cms.opts.Counter64 {
+		return math.MaxUint64
+	}
+	return math.MaxUint32
+}
+
+func (cms *EntryCMS) load(i, j uint32) uint64 {
+	if cms.opts.Counter64 {
+		return atomic.LoadUint64(&cms.counter64[i][j])
+	}
+	return uint64(atomic.LoadUint32(&cms.counter[i][j]))
+}
+
+func (cms *EntryCMS) store(i, j uint32, v uint64) {
+	if cms.opts.Counter64 {
+		atomic.StoreUint64(&cms.counter64[i][j], v)
+		return
+	}
+	atomic.StoreUint32(&cms.counter[i][j], uint32(v))
+}
+
+// add adds v to a counter, saturating at maxCount, and returns the new value
+// and whether it saturated.
+func (cms *EntryCMS) add(cur, v uint64) (uint64, bool) {
+	if v > cms.maxCount()-cur {
+		return cms.maxCount(), true
+	}
+	return cur + v, false
+}
+
+// cmsCells returns the column of item in every row of a width x depth sketch.
+func cmsCells(item string, width, depth uint32) []uint32 {
+	cols := make([]uint32, depth)
+	for i := range cols {
+		cols[i] = calcHash(item, uint32(i)) % width
+	}
+	return cols
+}
+
+func (cms *EntryCMS) cells(item string) []uint32 {
+	return cmsCells(item, cms.width, cms.depth)
+}
+
+// estimate returns the smallest of the item's counters.
+func (cms *EntryCMS) estimate(cols []uint32) uint64 {
+	minCount := cms.maxCount()
+	for i, j := range cols {
+		if val := cms.load(uint32(i), j); val < minCount {
+			minCount = val
+		}
+	}
+	return minCount
+}
+
+// incr adds value to the count of the item at cols and returns its new
+// estimate, reporting whether a counter overflowed.
+func (cms *EntryCMS) incr(cols []uint32, value uint64) (uint64, bool) {
+	var overflow bool
+	if cms.opts.Conservative {
+		var est uint64
+		est, overflow = cms.add(cms.estimate(cols), value)
+		for i, j := range cols {
+			if cms.load(uint32(i), j) < est {
+				cms.store(uint32(i), j, est)
+			}
+		}
+	} else {
+		for i, j := range cols {
+			v, o := cms.add(cms.load(uint32(i), j), value)
+			cms.store(uint32(i), j, v)
+			overflow = overflow || o
+		}
+	}
+	atomic.AddUint64(&cms.count, value)
+	return cms.estimate(cols), overflow
+}
+
+// cmsSketch is a Count-Min Sketch counting either all time or over a window.
+type cmsSketch interface {
+	incrBy(item string, value uint64) (uint64, bool)
+	estimateOf(item string) uint64
+	reset()
+	info() CMSInfo
+}
+
+func (cms *EntryCMS) incrBy(item string, value uint64) (uint64, bool) {
+	return cms.incr(cms.cells(item), value)
+}
+
+func (cms *EntryCMS) estimateOf(item string) uint64 {
+	return cms.estimate(cms.cells(item))
+}
+
+func (cms *EntryCMS) reset() {
+	for i := uint32(0); i < cms.depth; i++ {
+		for j := uint32(0); j < cms.width; j++ {
+			cms.store(i, j, 0)
+		}
+	}
+	atomic.StoreUint64(&cms.count, 0)
+}
+
+func (cms *EntryCMS) info() CMSInfo {
+	return CMSInfo{
+		Width:      cms.width,
+		Depth:      cms.depth,
+		Count:      atomic.LoadUint64(&cms.count),
+		CMSOptions: cms.opts,
+	}
+}
+
+// getSketch returns the sketch at key, windowed or not.
+func (s *Datastore) getSketch(key string) (cmsSketch, error) {
 	e, ok := s.getEntry(key)
 	if !ok {
 		return nil, config.ErrKeyNotExist
 	}
-	cms, ok := e.val.(*EntryCMS)
+	sketch, ok := e.val.(cmsSketch)
 	if !ok {
 		return nil, config.ErrWrongType
 	}
-	return cms, nil
+	return sketch, nil
 }
 
-func (s *Datastore) CreateCMS(key string, w, d uint32) (bool, error) {
+// createSketch stores sketch at key unless a sketch is there already.
+func (s *Datastore) createSketch(key string, sketch cmsSketch) (bool, error) {
 	if e, ok := s.m[key]; ok {
-		if _, ok := e.val.(*EntryCMS); ok {
+		if _, ok := e.val.(cmsSketch); ok {
 			return false, nil
 		}
 		return false, config.ErrWrongType
 	}
-	s.m[key] = Entry{val: CreateEntryCMS(w, d)}
+	s.m[key] = Entry{val: sketch}
 	return true, nil
 }
 
-func (s *Datastore) CreateCMSByProb(key string, errRate, errProb float64) (bool, error) {
+func (s *Datastore) getCMS(key string) (*EntryCMS, error) {
+	e, ok := s.getEntry(key)
+	if !ok {
+		return nil, config.ErrKeyNotExist
+	}
+	cms, ok := e.val.(*EntryCMS)
+	if !ok {
+		return nil, config.ErrWrongType
+	}
+	return cms, nil
+}
+
+func (s *Datastore) CreateCMS(key string, w, d uint32, opts CMSOptions) (bool, error) {
+	if cmsBytes(float64(w), float64(d), opts) > cmsMaxBytes {
+		return false, config.ErrCMSTooLarge
+	}
+	return s.createSketch(key, CreateEntryCMS(w, d, opts))
+}
+
+func (s *Datastore) CreateCMSByProb(key string, errRate, errProb float64, opts CMSOptions) (bool, error) {
+	// checked before CalcCMSDim, whose dimensions may not fit in an uint32
+	if cmsBytes(math.Ceil(math.E/errRate), math.Ceil(math.Log(1.0/errProb)), opts) > cmsMaxBytes {
+		return false, config.ErrCMSTooLarge
+	}
 	w, d := CalcCMSDim(errRate, errProb)
-	return s.CreateCMS(key, w, d)
+	return s.CreateCMS(key, w, d, opts)
 }
 
-// IncrBy increments the estimated count of an item by 'value'.
-func (s *Datastore) IncrBy(key, item string, value uint32) (uint32, error) {
-	cms, err := s.getCMS(key)
+// IncrBy increments the estimated count of an item by 'value'. The bool
+// reports that a counter overflowed and was capped.
+func (s *Datastore) IncrBy(key, item string, value uint64) (uint64, bool, error) {
+	sketch, err := s.getSketch(key)
 	if err != nil {
-		return 0, err
+		return 0, false, err
 	}
 
-	minCount := ^uint32(0)
-	for i := uint32(0); i < cms.depth; i++ {
-		hash := calcHash(item, i)
-		j := hash % cms.width
-		newVal := atomic.AddUint32(&cms.counter[i][j], value)
-		if newVal < minCount {
-			minCount = newVal
-		}
-	}
-	return minCount, nil
+	count, overflow := sketch.incrBy(item, value)
+	return count, overflow, nil
 }
 
 // Count estimates the frequency of an item.
-func (s *Datastore) Count(key, item string) (uint32, error) {
-	cms, err := s.getCMS(key)
+func (s *Datastore) Count(key, item string) (uint64, error) {
+	sketch, err := s.getSketch(key)
 	if err != nil {
 		return 0, err
 	}
-
-	minCount := ^uint32(0)
-	for i := uint32(0); i < cms.depth; i++ {
-		hash := calcHash(item, i)
-		j := hash % cms.width
-		val := atomic.LoadUint32(&cms.counter[i][j])
-		if val < minCount {
-			minCount = val
-		}
-	}
-	return minCount, nil
+	return sketch.estimateOf(item), nil
 }
 
 // Query multiple items
-func (s *Datastore) Query(key string, listItem []string) ([]uint32, error) {
-	cms, err := s.getCMS(key)
+func (s *Datastore) Query(key string, listItem []string) ([]uint64, error) {
+	sketch, err := s.getSketch(key)
 	if err != nil {
 		return nil, err
 	}
 
-	res := make([]uint32, len(listItem))
+	res := make([]uint64, len(listItem))
 	for idx, item := range listItem {
-		minCount := ^uint32(0)
-		for i := uint32(0); i < cms.depth; i++ {
-			hash := calcHash(item, i)
-			j := hash % cms.width
-			val := atomic.LoadUint32(&cms.counter[i][j])
-			if val < minCount {
-				minCount = val
-			}
-		}
-		res[idx] = minCount
+		res[idx] = sketch.estimateOf(item)
 	}
 	return res, nil
 }
 
 // Reset clears all counters for a given key.
 func (s *Datastore) Reset(key string) error {
-	cms, err := s.getCMS(key)
+	sketch, err := s.getSketch(key)
 	if err != nil {
 		return err
 	}
+	sketch.reset()
+	return nil
+}
 
-	for i := range cms.counter {
-		for j := range cms.counter[i] {
-			atomic.StoreUint32(&cms.counter[i][j], 0)
+// CloneCMS returns a copy of the sketch at key, safe to read from another
+// goroutine.
+func (s *Datastore) CloneCMS(key string) (*EntryCMS, error) {
+	cms, err := s.getCMS(key)
+	if err != nil {
+		return nil, err
+	}
+
+	clone := CreateEntryCMS(cms.width, cms.depth, cms.opts)
+	for i := uint32(0); i < cms.depth; i++ {
+		for j := uint32(0); j < cms.width; j++ {
+			clone.store(i, j, cms.load(i, j))
 		}
 	}
-	return nil
+	clone.count = atomic.LoadUint64(&cms.count)
+	return clone, nil
 }
 
-func (s *Datastore) Merge(key string, other *EntryCMS) error {
+// Merge overwrites the counters at key with the sum of the sources' counters,
+// each multiplied by its weight. All the sketches must have the same dimensions.
+func (s *Datastore) Merge(key string, srcs []*EntryCMS, weights []uint32) error {
 	cms, err := s.getCMS(key)
 	if err != nil {
 		return err
 	}
-	if cms.width != other.width || cms.depth != other.depth {
-		return errors.New("CMS: dimensions do not match")
+	for _, src := range srcs {
+		if src.width != cms.width || src.depth != cms.depth {
+			return config.ErrCMSDimensionsMismatch
+		}
 	}
 
+	merged := make([][]uint64, cms.depth)
 	for i := uint32(0); i < cms.depth; i++ {
+		merged[i] = make([]uint64, cms.width)
 		for j := uint32(0); j < cms.width; j++ {
-			ov := atomic.LoadUint32(&other.counter[i][j])
-			if ov > 0 {
-				atomic.AddUint32(&cms.counter[i][j], ov)
+			var sum uint64
+			for k, src := range srcs {
+				v, w := src.load(i, j), uint64(weights[k])
+				// the sum must fit the destination's counters
+				if w != 0 && v > (cms.maxCount()-sum)/w {
+					return config.ErrCMSMergeOverflow
+				}
+				sum += v * w
 			}
+			merged[i][j] = sum
+		}
+	}
+	var count uint64
+	for k, src := range srcs {
+		count += src.count * uint64(weights[k])
+	}
+
+	for i := range merged {
+		for j := range merged[i] {
+			cms.store(uint32(i), uint32(j), merged[i][j])
 		}
 	}
+	atomic.StoreUint64(&cms.count, count)
 	return nil
 }
 
-// Info returns the CMS dimensions.
-func (s *Datastore) Info(key string) (uint32, uint32, error) {
-	cms, err := s.getCMS(key)
+// CMSInfo describes a sketch for CMS.INFO.
+type CMSInfo struct {
+	Width, Depth uint32
+	// Count is the total count of increments.
+	Count uint64
+	CMSOptions
+	// Window and Granularity are set for windowed sketches.
+	Window, Granularity time.Duration
+}
+
+// Info returns the CMS dimensions, options and total count of increments.
+func (s *Datastore) Info(key string) (CMSInfo, error) {
+	sketch, err := s.getSketch(key)
 	if err != nil {
-		return 0, 0, err
+		return CMSInfo{}, err
 	}
-	return cms.width, cms.depth, nil
+	return sketch.info(), nil
 }
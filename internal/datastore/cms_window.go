@@ -0,0 +1,115 @@
+package datastore
+
+import (
+	"backend/internal/config"
+	"time"
+)
+
+// maxWindowBuckets bounds the sub-sketches of a windowed sketch, which each
+// take as much memory as a plain one.
+const maxWindowBuckets = 1024
+
+// EntryWindowedCMS is a Count-Min Sketch over a sliding window of time. Time
+// is cut into slots of granularity and each slot is counted by its own
+// sub-sketch, kept in a ring. Estimates add up the sub-sketches of the slots
+// still in the window, so they cover between window-granularity and window
+// of the latest time. Sub-sketches whose slot has left the window are freed
+// the next time the sketch is accessed.
+type EntryWindowedCMS struct {
+	width, depth uint32
+	opts         CMSOptions
+	window       time.Duration
+	granularity  time.Duration
+	// buckets[i] counts slot epochs[i], or is nil when that slot expired
+	buckets []*EntryCMS
+	epochs  []int64
+}
+
+func CreateEntryWindowedCMS(w, d uint32, opts CMSOptions, window, granularity time.Duration) *EntryWindowedCMS {
+	n := int((window + granularity - 1) / granularity)
+	return &EntryWindowedCMS{
+		width:       w,
+		depth:       d,
+		opts:        opts,
+		window:      window,
+		granularity: granularity,
+		buckets:     make([]*EntryCMS, n),
+		epochs:      make([]int64, n),
+	}
+}
+
+// rotate frees the sub-sketches that left the window and returns the current slot.
+func (wcms *EntryWindowedCMS) rotate() int64 {
+	cur := time.Now().UnixNano() / int64(wcms.granularity)
+	oldest := cur - int64(len(wcms.buckets)) + 1
+	for i, b := range wcms.buckets {
+		if b != nil && wcms.epochs[i] < oldest {
+			wcms.buckets[i] = nil
+		}
+	}
+	return cur
+}
+
+// sum adds up the estimates of the live sub-sketches, saturating like a counter.
+func (wcms *EntryWindowedCMS) sum(cols []uint32) uint64 {
+	var total uint64
+	for _, b := range wcms.buckets {
+		if b == nil {
+			continue
+		}
+		total, _ = b.add(total, b.estimate(cols))
+	}
+	return total
+}
+
+func (wcms *EntryWindowedCMS) incrBy(item string, value uint64) (uint64, bool) {
+	cur := wcms.rotate()
+	i := int(cur % int64(len(wcms.buckets)))
+	if wcms.buckets[i] == nil {
+		wcms.buckets[i] = CreateEntryCMS(wcms.width, wcms.depth, wcms.opts)
+		wcms.epochs[i] = cur
+	}
+
+	cols := cmsCells(item, wcms.width, wcms.depth)
+	_, overflow := wcms.buckets[i].incr(cols, value)
+	return wcms.sum(cols), overflow
+}
+
+func (wcms *EntryWindowedCMS) estimateOf(item string) uint64 {
+	wcms.rotate()
+	return wcms.sum(cmsCells(item, wcms.width, wcms.depth))
+}
+
+func (wcms *EntryWindowedCMS) reset() {
+	clear(wcms.buckets)
+}
+
+func (wcms *EntryWindowedCMS) info() CMSInfo {
+	wcms.rotate()
+	info := CMSInfo{
+		Width:       wcms.width,
+		Depth:       wcms.depth,
+		CMSOptions:  wcms.opts,
+		Window:      wcms.window,
+		Granularity: wcms.granularity,
+	}
+	for _, b := range wcms.buckets {
+		if b != nil {
+			info.Count += b.count
+		}
+	}
+	return info
+}
+
+// CreateWindowedCMS creates a sketch counting over the last window of time,
+// in steps of granularity.
+func (s *Datastore) CreateWindowedCMS(key string, w, d uint32, opts CMSOptions, window, granularity time.Duration) (bool, error) {
+	if granularity <= 0 || window < granularity || (window+granularity-1)/granularity > maxWindowBuckets {
+		return false, config.ErrCMSInvalidWindow
+	}
+	n := float64((window + granularity - 1) / granularity)
+	if n*cmsBytes(float64(w), float64(d), opts) > cmsMaxBytes {
+		return false, config.ErrCMSTooLarge
+	}
+	return s.createSketch(key, CreateEntryWindowedCMS(w, d, opts, window, granularity))
+}
@@ -0,0 +1,331 @@
+package datastore
+
+import (
+	"backend/internal/config"
+	"math/bits"
+
+	"github.com/spaolacci/murmur3"
+)
+
+// A cuckoo filter stores an 8-bit fingerprint of each item in one of two
+// buckets: the item's hash picks the first and the second is the first xored
+// with a hash of the fingerprint, so either can be found from the other and
+// entries can move between them without knowing the item. An item whose
+// buckets are both full evicts one of their entries to its other bucket, and so on
+// for up to maxIterations moves. Unlike a Bloom filter, items can be deleted.
+// A full filter grows by chaining another one, expansion times larger.
+
+const (
+	cuckooAltMultiplier = 0x5bd1e995
+
+	cuckooEmptyFingerprint = 0
+
+	CuckooMaxBucketSize = 255
+	CuckooMaxIterations = 65535
+	CuckooMaxExpansion  = 32768
+
+	// cuckooMaxSlots caps the slots of all the filters of a key, 512MB.
+	cuckooMaxSlots    = 1 << 29
+	CuckooMaxCapacity = cuckooMaxSlots
+
+	CuckooDefaultCapacity      = 1024
+	CuckooDefaultBucketSize    = 2
+	CuckooDefaultMaxIterations = 20
+	CuckooDefaultExpansion     = 1
+)
+
+// CuckooOptions are the settings of CF.RESERVE.
+type CuckooOptions struct {
+	Capacity      uint64
+	BucketSize    uint64
+	MaxIterations uint64
+	// Expansion is 0 for a filter that does not grow
+	Expansion uint64
+}
+
+type cuckooFilter struct {
+	numBuckets uint64
+	// slots holds numBuckets buckets of bucketSize fingerprints each
+	slots []uint8
+}
+
+type EntryCuckoo struct {
+	opts    CuckooOptions
+	filters []*cuckooFilter
+	// slots is the number of slots of all filters
+	slots      uint64
+	numItems   uint64
+	numDeletes uint64
+	// kick is the position of the next eviction within a bucket
+	kick uint64
+}
+
+func nextPow2(n uint64) uint64 {
+	if n <= 1 {
+		return 1
+	}
+	return 1 << bits.Len64(n-1)
+}
+
+func CreateEntryCuckoo(opts CuckooOptions) (*EntryCuckoo, error) {
+	c := &EntryCuckoo{opts: opts}
+	if !c.grow((opts.Capacity + opts.BucketSize - 1) / opts.BucketSize) {
+		return nil, config.ErrCFTooLarge
+	}
+	return c, nil
+}
+
+// grow chains a filter of at least numBuckets buckets, or reports false if
+// it would take the key past cuckooMaxSlots.
+func (c *EntryCuckoo) grow(numBuckets uint64) bool {
+	if numBuckets > cuckooMaxSlots {
+		return false
+	}
+	numBuckets = nextPow2(numBuckets)
+	slots := numBuckets * c.opts.BucketSize
+	if slots > cuckooMaxSlots-c.slots {
+		return false
+	}
+	c.filters = append(c.filters, &cuckooFilter{
+		numBuckets: numBuckets,
+		slots:      make([]uint8, slots),
+	})
+	c.slots += slots
+	return true
+}
+
+// cuckooHash returns the fingerprint of item and the hashes of its two
+// buckets, before reducing them to a filter's number of buckets.
+func cuckooHash(item string) (uint8, uint64, uint64) {
+	h := murmur3.Sum64([]byte(item))
+	fp := uint8(h%255 + 1)
+	return fp, h, cuckooAlt(fp, h)
+}
+
+func cuckooAlt(fp uint8, h uint64) uint64 {
+	return h ^ uint64(fp)*cuckooAltMultiplier
+}
+
+func (c *EntryCuckoo) bucket(f *cuckooFilter, h uint64) []uint8 {
+	i := h & (f.numBuckets - 1)
+	return f.slots[i*c.opts.BucketSize : (i+1)*c.opts.BucketSize]
+}
+
+// count returns how many times fp occurs in the buckets h1 and h2 of all filters.
+func (c *EntryCuckoo) count(fp uint8, h1, h2 uint64) int {
+	n := 0
+	for _, f := range c.filters {
+		for _, slot := range c.bucket(f, h1) {
+			if slot == fp {
+				n++
+			}
+		}
+		if h1&(f.numBuckets-1) == h2&(f.numBuckets-1) {
+			continue
+		}
+		for _, slot := range c.bucket(f, h2) {
+			if slot == fp {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// place puts fp in a free slot of bucket h1 or h2 of f.
+func (c *EntryCuckoo) place(f *cuckooFilter, fp uint8, h1, h2 uint64) bool {
+	for _, h := range [2]uint64{h1, h2} {
+		b := c.bucket(f, h)
+		for i, slot := range b {
+			if slot == cuckooEmptyFingerprint {
+				b[i] = fp
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// relocate makes room for fp in f by evicting entries to their other
+// buckets. A failed attempt is undone, leaving f as it was.
+func (c *EntryCuckoo) relocate(f *cuckooFilter, fp uint8, h uint64) bool {
+	type move struct {
+		h   uint64
+		pos uint64
+	}
+	var moves []move
+	for n := uint64(0); n < c.opts.MaxIterations; n++ {
+		pos := c.kick % c.opts.BucketSize
+		c.kick++
+		b := c.bucket(f, h)
+		fp, b[pos] = b[pos], fp
+		moves = append(moves, move{h, pos})
+
+		h = cuckooAlt(fp, h)
+		b = c.bucket(f, h)
+		for i, slot := range b {
+			if slot == cuckooEmptyFingerprint {
+				b[i] = fp
+				return true
+			}
+		}
+	}
+
+	// put every evicted fingerprint back, newest first
+	for i := len(moves) - 1; i >= 0; i-- {
+		b := c.bucket(f, moves[i].h)
+		fp, b[moves[i].pos] = b[moves[i].pos], fp
+	}
+	return false
+}
+
+// insert adds fp, growing the filter if needed, and reports false when the
+// filter is full and may not grow.
+func (c *EntryCuckoo) insert(fp uint8, h1, h2 uint64) bool {
+	for i := len(c.filters) - 1; i >= 0; i-- {
+		if c.place(c.filters[i], fp, h1, h2) {
+			c.numItems++
+			return true
+		}
+	}
+	if c.relocate(c.filters[len(c.filters)-1], fp, h1) {
+		c.numItems++
+		return true
+	}
+	if c.opts.Expansion == 0 {
+		return false
+	}
+	last := c.filters[len(c.filters)-1]
+	if c.opts.Expansion > cuckooMaxSlots/last.numBuckets || !c.grow(last.numBuckets*c.opts.Expansion) {
+		return false
+	}
+	c.place(c.filters[len(c.filters)-1], fp, h1, h2)
+	c.numItems++
+	return true
+}
+
+// remove deletes one occurrence of fp, newest filter first.
+func (c *EntryCuckoo) remove(fp uint8, h1, h2 uint64) bool {
+	for i := len(c.filters) - 1; i >= 0; i-- {
+		for _, h := range [2]uint64{h1, h2} {
+			b := c.bucket(c.filters[i], h)
+			for j, slot := range b {
+				if slot == fp {
+					b[j] = cuckooEmptyFingerprint
+					c.numItems--
+					c.numDeletes++
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func (s *Datastore) getCuckoo(key string) (*EntryCuckoo, bool, error) {
+	e, ok := s.getEntry(key)
+	if !ok {
+		return nil, false, nil
+	}
+	c, ok := e.val.(*EntryCuckoo)
+	if !ok {
+		return nil, false, config.ErrWrongType
+	}
+	return c, true, nil
+}
+
+// CF.RESERVE
+func (s *Datastore) CreateCuckoo(key string, opts CuckooOptions) (bool, error) {
+	if e, ok := s.getEntry(key); ok {
+		if _, ok := e.val.(*EntryCuckoo); ok {
+			return false, nil
+		}
+		return false, config.ErrWrongType
+	}
+	c, err := CreateEntryCuckoo(opts)
+	if err != nil {
+		return false, err
+	}
+	s.m[key] = Entry{val: c}
+	return true, nil
+}
+
+// CuckooInsertOptions control CF.ADD, CF.ADDNX and CF.INSERT.
+type CuckooInsertOptions struct {
+	// NX skips items that may already be in the filter.
+	NX bool
+	// NoCreate fails on a missing filter instead of creating one.
+	NoCreate bool
+	// Capacity sizes a created filter, CuckooDefaultCapacity when 0.
+	Capacity uint64
+}
+
+// CF.INSERT
+// Each result is 1 when the item was added, 0 when NX found it already
+// present and -1 when the filter was full.
+func (s *Datastore) CuckooInsert(key string, items []string, opts CuckooInsertOptions) ([]int, error) {
+	c, ok, err := s.getCuckoo(key)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		if opts.NoCreate {
+			return nil, config.ErrCFNotFound
+		}
+		copts := CuckooOptions{
+			Capacity:      opts.Capacity,
+			BucketSize:    CuckooDefaultBucketSize,
+			MaxIterations: CuckooDefaultMaxIterations,
+			Expansion:     CuckooDefaultExpansion,
+		}
+		if copts.Capacity == 0 {
+			copts.Capacity = CuckooDefaultCapacity
+		}
+		if c, err = CreateEntryCuckoo(copts); err != nil {
+			return nil, err
+		}
+		s.m[key] = Entry{val: c}
+	}
+
+	res := make([]int, len(items))
+	for i, item := range items {
+		fp, h1, h2 := cuckooHash(item)
+		switch {
+		case opts.NX && c.count(fp, h1, h2) > 0:
+			res[i] = 0
+		case c.insert(fp, h1, h2):
+			res[i] = 1
+		default:
+			res[i] = -1
+		}
+	}
+	return res, nil
+}
+
+// CF.EXISTS
+func (s *Datastore) CuckooExists(key, item string) (bool, error) {
+	n, err := s.CuckooCount(key, item)
+	return n > 0, err
+}
+
+// CF.COUNT
+// The count may be higher than the item's when fingerprints collide.
+func (s *Datastore) CuckooCount(key, item string) (int, error) {
+	c, ok, err := s.getCuckoo(key)
+	if err != nil || !ok {
+		return 0, err
+	}
+	return c.count(cuckooHash(item)), nil
+}
+
+// CF.DEL
+func (s *Datastore) CuckooDel(key, item string) (bool, error) {
+	c, ok, err := s.getCuckoo(key)
+	if err != nil {
+		return false, err
+	}
+	if !ok {
+		return false, config.ErrCFNotFound
+	}
+	return c.remove(cuckooHash(item)), nil
+}
@@ -1,7 +1,9 @@
 package datastore
 
 import (
+	"backend/internal/config"
 	"path"
+	"strconv"
 	"time"
 )
 
@@ -12,11 +14,40 @@ type Entry struct {
 
 type Datastore struct {
 	m map[string]Entry
+	// expires indexes the keys given a TTL, see expire.go
+	expires    expireIndex
+	setLimits  setLimits
+	zsetLimits zsetLimits
+	// bloomDefaults configure filters created by BF.ADD
+	bloomDefaults bloomDefaults
+	// hllSparseMaxBytes is the largest a sparse HyperLogLog gets before turning dense
+	hllSparseMaxBytes int
+	expireLimits      expireLimits
 }
 
 func NewDataStore() *Datastore {
 	return &Datastore{
-		m: make(map[string]Entry),
+		m:       make(map[string]Entry),
+		expires: newExpireIndex(),
+		setLimits: setLimits{
+			maxIntsetEntries:   config.GetInt("set.maxIntsetEntries"),
+			maxListpackEntries: config.GetInt("set.maxListpackEntries"),
+			maxListpackValue:   config.GetInt("set.maxListpackValue"),
+		},
+		zsetLimits: zsetLimits{
+			maxListpackEntries: config.GetInt("zset.maxListpackEntries"),
+			maxListpackValue:   config.GetInt("zset.maxListpackValue"),
+		},
+		bloomDefaults: bloomDefaults{
+			errorRate: config.GetFloat64("bf.errorRate"),
+			capacity:  uint64(config.GetInt("bf.initialCapacity")),
+			expansion: uint64(config.GetInt("bf.expansion")),
+		},
+		hllSparseMaxBytes: config.GetInt("hll.sparseMaxBytes"),
+		expireLimits: expireLimits{
+			keysPerLoop:     config.GetInt("expire.keysPerLoop"),
+			acceptableStale: config.GetInt("expire.acceptableStalePercent"),
+		},
 	}
 }
 
@@ -31,6 +62,7 @@ func (s *Datastore) getEntry(key string) (Entry, bool) {
 	}
 	if s.isExpired(e) {
 		delete(s.m, key)
+		s.expires.remove(key)
 		return Entry{}, false
 	}
 	return e, true
@@ -53,3 +85,44 @@ func (s *Datastore) Keys(pattern string) []string {
 	}
 	return res
 }
+
+// ObjectEncoding names the internal representation of the value at key.
+func (s *Datastore) ObjectEncoding(key string) (string, bool) {
+	e, ok := s.getEntry(key)
+	if !ok {
+		return "", false
+	}
+
+	switch v := e.val.(type) {
+	case string:
+		if _, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return "int", true
+		}
+		if len(v) <= 44 {
+			return "embstr", true
+		}
+		return "raw", true
+	case *EntrySimpleSet:
+		return v.enc.String(), true
+	case *EntryList:
+		return "linkedlist", true
+	case *EntryZSetBPTree:
+		if v.isListpack() {
+			return "listpack", true
+		}
+		return "bptree", true
+	case *EntryStream:
+		return "stream", true
+	case *EntryCMS, *EntryWindowedCMS:
+		return "cms", true
+	case *EntryTopK:
+		return "topk", true
+	case *EntryBloom:
+		return "bloom", true
+	case *EntryCuckoo:
+		return "cuckoo", true
+	case *EntryTDigest:
+		return "tdigest", true
+	}
+	return "raw", true
+}
@@ -0,0 +1,92 @@
+package datastore
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Expired keys are deleted lazily when accessed, and actively by a cycle the
+// worker runs periodically for keys that are never accessed again. The cycle
+// samples keys at random from expires, the index of keys given a TTL, and
+// deletes those past it. As long as enough of a sample has expired, the
+// keyspace likely holds many more, so it samples again until the ratio drops
+// or its time runs out.
+//
+// The index is loose: keys overwritten without their TTL or deleted along with
+// their value may linger in it until sampled.
+
+type expireLimits struct {
+	// keysPerLoop is the size of each sample
+	keysPerLoop int
+	// acceptableStale is the percentage of expired keys in a sample below
+	// which the cycle stops
+	acceptableStale int
+}
+
+// expireTimeCheckLoops is how many samples the cycle takes between checks of
+// its time budget.
+const expireTimeCheckLoops = 16
+
+// expireIndex keeps keys in a slice, to pick them at random, along with their
+// positions in it, to remove them in constant time.
+type expireIndex struct {
+	keys []string
+	pos  map[string]int
+}
+
+func newExpireIndex() expireIndex {
+	return expireIndex{pos: make(map[string]int)}
+}
+
+func (x *expireIndex) add(key string) {
+	if _, ok := x.pos[key]; ok {
+		return
+	}
+	x.pos[key] = len(x.keys)
+	x.keys = append(x.keys, key)
+}
+
+func (x *expireIndex) remove(key string) {
+	i, ok := x.pos[key]
+	if !ok {
+		return
+	}
+	last := len(x.keys) - 1
+	x.keys[i] = x.keys[last]
+	x.pos[x.keys[i]] = i
+	x.keys = x.keys[:last]
+	delete(x.pos, key)
+}
+
+// ActiveExpire runs one expiration cycle within budget and returns the number
+// of keys it deleted.
+func (s *Datastore) ActiveExpire(budget time.Duration) int {
+	start := time.Now()
+	deleted := 0
+	for loop := 1; len(s.expires.keys) > 0; loop++ {
+		now := time.Now()
+		sampled, reclaimed := 0, 0
+		for ; sampled < s.expireLimits.keysPerLoop && len(s.expires.keys) > 0; sampled++ {
+			key := s.expires.keys[rand.Intn(len(s.expires.keys))]
+			e, ok := s.m[key]
+			switch {
+			case !ok || e.expireAt == nil:
+				s.expires.remove(key)
+				reclaimed++
+			case now.After(*e.expireAt):
+				delete(s.m, key)
+				s.expires.remove(key)
+				reclaimed++
+				deleted++
+			}
+		}
+
+		if reclaimed*100 <= sampled*s.expireLimits.acceptableStale {
+			break
+		}
+		if loop%expireTimeCheckLoops == 0 && time.Since(start) > budget {
+			break
+		}
+	}
+	return deleted
+}
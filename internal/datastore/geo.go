@@ -0,0 +1,187 @@
+package datastore
+
+import (
+	"backend/internal/config"
+	"math"
+	"sort"
+)
+
+type GeoPoint struct {
+	Lon, Lat float64
+	Member   string
+}
+
+// GeoQuery is a parsed GEOSEARCH. The center is Member's position when
+// Member is set and (Lon, Lat) otherwise. Distances are in meters.
+type GeoQuery struct {
+	Member   string
+	Lon, Lat float64
+
+	ByBox         bool
+	Radius        float64
+	Width, Height float64
+
+	// Sort is 1 for nearest first, -1 for farthest first, 0 for any order.
+	Sort int
+	// Count caps the matches when positive. With Any the search stops as
+	// soon as it has Count of them instead of returning the nearest ones.
+	Count int
+	Any   bool
+}
+
+type GeoMatch struct {
+	Member   string
+	Dist     float64
+	Hash     uint64
+	Lon, Lat float64
+}
+
+// GEOADD
+func (s *Datastore) GeoAdd(key string, opts ZAddOptions, points []GeoPoint) (int, error) {
+	scores := make([]float64, len(points))
+	members := make([]string, len(points))
+	for i, p := range points {
+		scores[i] = float64(geoEncode(p.Lon, p.Lat, geoStepMax))
+		members[i] = p.Member
+	}
+	return s.zaddScores(key, opts, scores, members)
+}
+
+// GEOPOS
+// found[i] reports whether members[i] is in the set.
+func (s *Datastore) GeoPos(key string, members []string) ([]GeoPoint, []bool, error) {
+	scores, found, err := s.ZMScore(key, members)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	points := make([]GeoPoint, len(members))
+	for i, score := range scores {
+		if found[i] {
+			lon, lat := geoDecode(score)
+			points[i] = GeoPoint{Lon: lon, Lat: lat, Member: members[i]}
+		}
+	}
+	return points, found, nil
+}
+
+// GEODIST
+// It returns the distance in meters, or false if either member is missing.
+func (s *Datastore) GeoDist(key, member1, member2 string) (float64, bool, error) {
+	points, found, err := s.GeoPos(key, []string{member1, member2})
+	if err != nil || !found[0] || !found[1] {
+		return 0, false, err
+	}
+	return geoDistance(points[0].Lon, points[0].Lat, points[1].Lon, points[1].Lat), true, nil
+}
+
+// GEOHASH
+func (s *Datastore) GeoHash(key string, members []string) ([]string, []bool, error) {
+	points, found, err := s.GeoPos(key, members)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	hashes := make([]string, len(members))
+	for i, p := range points {
+		if found[i] {
+			hashes[i] = geoHashString(p.Lon, p.Lat)
+		}
+	}
+	return hashes, found, nil
+}
+
+// match returns the distance from the center of q to a point, and false when
+// the point lies outside the searched shape.
+func (q *GeoQuery) match(lon, lat float64) (float64, bool) {
+	if !q.ByBox {
+		dist := geoDistance(q.Lon, q.Lat, lon, lat)
+		return dist, dist <= q.Radius
+	}
+
+	// north-south, then east-west along the point's own latitude
+	if earthRadiusMeters*math.Abs(degToRad(lat)-degToRad(q.Lat)) > q.Height/2 {
+		return 0, false
+	}
+	if geoDistance(q.Lon, lat, lon, lat) > q.Width/2 {
+		return 0, false
+	}
+	return geoDistance(q.Lon, q.Lat, lon, lat), true
+}
+
+// GEOSEARCH
+func (s *Datastore) GeoSearch(key string, q GeoQuery) ([]GeoMatch, error) {
+	zset, err := s.getZSet(key)
+	if err != nil || zset == nil {
+		return nil, err
+	}
+
+	if q.Member != "" {
+		score, ok := zset.score(q.Member)
+		if !ok {
+			return nil, config.ErrGeoMemberNotFound
+		}
+		q.Lon, q.Lat = geoDecode(score)
+	}
+
+	radius, halfW, halfH := q.Radius, q.Radius, q.Radius
+	if q.ByBox {
+		halfW, halfH = q.Width/2, q.Height/2
+		radius = math.Hypot(halfW, halfH)
+	}
+
+	var matches []GeoMatch
+	limited := q.Any && q.Count > 0
+scan:
+	for _, r := range geoSearchRanges(q.Lon, q.Lat, radius, halfW, halfH) {
+		lo := zset.countBefore(func(k bptKey) bool { return k.score >= r[0] })
+		hi := zset.countBefore(func(k bptKey) bool { return k.score >= r[1] })
+		if lo >= hi {
+			continue
+		}
+		for _, k := range zset.rangeByRank(lo, hi-1) {
+			lon, lat := geoDecode(k.score)
+			dist, ok := q.match(lon, lat)
+			if !ok {
+				continue
+			}
+			matches = append(matches, GeoMatch{Member: k.member, Dist: dist, Hash: uint64(k.score), Lon: lon, Lat: lat})
+			if limited && len(matches) == q.Count {
+				break scan
+			}
+		}
+	}
+
+	if q.Sort != 0 {
+		sort.SliceStable(matches, func(i, j int) bool {
+			if q.Sort > 0 {
+				return matches[i].Dist < matches[j].Dist
+			}
+			return matches[i].Dist > matches[j].Dist
+		})
+	}
+	if q.Count > 0 && len(matches) > q.Count {
+		matches = matches[:q.Count]
+	}
+	return matches, nil
+}
+
+// GEOSEARCHSTORE
+// It returns the scores to store, for the coordinator to do so with ZStore:
+// the geohashes or, when distUnit is positive, the distances in units of
+// distUnit meters.
+func (s *Datastore) GeoSearchScores(key string, q GeoQuery, distUnit float64) (map[string]float64, error) {
+	matches, err := s.GeoSearch(key, q)
+	if err != nil {
+		return nil, err
+	}
+
+	scores := make(map[string]float64, len(matches))
+	for _, m := range matches {
+		scores[m.Member] = float64(m.Hash)
+		if distUnit > 0 {
+			scores[m.Member] = m.Dist / distUnit
+		}
+	}
+	return scores, nil
+}
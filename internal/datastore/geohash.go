@@ -0,0 +1,164 @@
+package datastore
+
+import (
+	"math"
+)
+
+// Geo members live in sorted sets scored by a 52 bit geohash: the latitude
+// and longitude are each quantized to 26 bits and their bits interleaved,
+// latitude first. Points close on the map then tend to have close scores, so
+// an area is searched by scanning the score ranges of the geohash cells
+// around it and filtering the candidates by their actual distance.
+
+const (
+	geoStepMax = 26
+
+	GeoLatMin = -85.05112878
+	GeoLatMax = 85.05112878
+	GeoLonMin = -180.0
+	GeoLonMax = 180.0
+
+	earthRadiusMeters = 6372797.560856
+	// half the circumference of the earth in the Mercator projection
+	mercatorMax = 20037726.37
+)
+
+const geoAlphabet = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+func interleave(lat, lon uint32) uint64 {
+	var h uint64
+	for i := 0; i < 32; i++ {
+		h |= uint64(lat>>i&1) << (2 * i)
+		h |= uint64(lon>>i&1) << (2*i + 1)
+	}
+	return h
+}
+
+func deinterleave(h uint64) (lat, lon uint32) {
+	for i := 0; i < 32; i++ {
+		lat |= uint32(h>>(2*i)&1) << i
+		lon |= uint32(h>>(2*i+1)&1) << i
+	}
+	return lat, lon
+}
+
+// quantize maps v within [min, max] to one of 2^step cells.
+func quantize(v, min, max float64, step uint) uint32 {
+	cells := uint64(1) << step
+	idx := uint64((v - min) / (max - min) * float64(cells))
+	return uint32(math.Min(float64(idx), float64(cells-1)))
+}
+
+func geoEncode(lon, lat float64, step uint) uint64 {
+	return interleave(quantize(lat, GeoLatMin, GeoLatMax, step), quantize(lon, GeoLonMin, GeoLonMax, step))
+}
+
+// geoCell returns the bounds of the geohash cell h at the given step.
+func geoCell(h uint64, step uint) (lonMin, lonMax, latMin, latMax float64) {
+	latIdx, lonIdx := deinterleave(h)
+	cells := float64(uint64(1) << step)
+	latMin = GeoLatMin + float64(latIdx)/cells*(GeoLatMax-GeoLatMin)
+	latMax = GeoLatMin + float64(latIdx+1)/cells*(GeoLatMax-GeoLatMin)
+	lonMin = GeoLonMin + float64(lonIdx)/cells*(GeoLonMax-GeoLonMin)
+	lonMax = GeoLonMin + float64(lonIdx+1)/cells*(GeoLonMax-GeoLonMin)
+	return lonMin, lonMax, latMin, latMax
+}
+
+// geoDecode returns the center of the cell a geo score stands for.
+func geoDecode(score float64) (lon, lat float64) {
+	lonMin, lonMax, latMin, latMax := geoCell(uint64(score), geoStepMax)
+	lon = math.Max(GeoLonMin, math.Min(GeoLonMax, (lonMin+lonMax)/2))
+	lat = math.Max(GeoLatMin, math.Min(GeoLatMax, (latMin+latMax)/2))
+	return lon, lat
+}
+
+// geoHashString renders a point as the standard 11 character base32 geohash,
+// which unlike the scores uses the full [-90, 90] latitude range.
+func geoHashString(lon, lat float64) string {
+	h := interleave(quantize(lat, -90, 90, geoStepMax), quantize(lon, GeoLonMin, GeoLonMax, geoStepMax))
+	buf := make([]byte, 11)
+	for i := range buf {
+		// the 52 bits fill ten characters, the eleventh is always '0'
+		var idx uint64
+		if i < 10 {
+			idx = h >> (52 - (i+1)*5) & 0x1f
+		}
+		buf[i] = geoAlphabet[idx]
+	}
+	return string(buf)
+}
+
+func degToRad(deg float64) float64 { return deg * math.Pi / 180 }
+func radToDeg(rad float64) float64 { return rad * 180 / math.Pi }
+
+// geoDistance is the haversine distance in meters between two points.
+func geoDistance(lon1, lat1, lon2, lat2 float64) float64 {
+	lat1r, lat2r := degToRad(lat1), degToRad(lat2)
+	u := math.Sin((lat2r - lat1r) / 2)
+	v := math.Sin(degToRad(lon2-lon1) / 2)
+	return 2 * earthRadiusMeters * math.Asin(math.Sqrt(u*u+math.Cos(lat1r)*math.Cos(lat2r)*v*v))
+}
+
+// geoEstimateStep picks the finest step whose cells are still likely to be
+// larger than radius at latitude lat.
+func geoEstimateStep(radius, lat float64) uint {
+	if radius == 0 {
+		return geoStepMax
+	}
+	step := 1
+	for ; radius < mercatorMax; radius *= 2 {
+		step++
+	}
+	step -= 2
+	// cells shrink towards the poles
+	if lat > 66 || lat < -66 {
+		step--
+		if lat > 80 || lat < -80 {
+			step--
+		}
+	}
+	return uint(min(max(step, 1), geoStepMax))
+}
+
+// geoSearchRanges returns the score ranges [lo, hi) of the cells around
+// (lon, lat) covering an area that reaches halfW meters east and west and
+// halfH meters north and south of it, radius being the distance to its
+// farthest point.
+func geoSearchRanges(lon, lat, radius, halfW, halfH float64) [][2]float64 {
+	latDelta := radToDeg(halfH / earthRadiusMeters)
+	lonDelta := radToDeg(halfW / earthRadiusMeters / math.Cos(degToRad(lat)))
+
+	// the center cell and its eight neighbors must contain the whole area
+	step := geoEstimateStep(radius, lat)
+	for ; step > 1; step-- {
+		lonMin, lonMax, latMin, latMax := geoCell(geoEncode(lon, lat, step), step)
+		cellW, cellH := lonMax-lonMin, latMax-latMin
+		if lat-latDelta >= latMin-cellH && lat+latDelta <= latMax+cellH &&
+			lon-lonDelta >= lonMin-cellW && lon+lonDelta <= lonMax+cellW {
+			break
+		}
+	}
+
+	latIdx, lonIdx := deinterleave(geoEncode(lon, lat, step))
+	cells := int64(1) << step
+	shift := 2 * (geoStepMax - step)
+	seen := make(map[uint64]bool, 9)
+	var ranges [][2]float64
+	for dLat := int64(-1); dLat <= 1; dLat++ {
+		li := int64(latIdx) + dLat
+		if li < 0 || li >= cells {
+			continue
+		}
+		for dLon := int64(-1); dLon <= 1; dLon++ {
+			// longitude wraps around
+			gi := (int64(lonIdx) + dLon + cells) % cells
+			h := interleave(uint32(li), uint32(gi))
+			if seen[h] {
+				continue
+			}
+			seen[h] = true
+			ranges = append(ranges, [2]float64{float64(h << shift), float64((h + 1) << shift)})
+		}
+	}
+	return ranges
+}
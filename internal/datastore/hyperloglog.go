@@ -0,0 +1,456 @@
+package datastore
+
+import (
+	"backend/internal/config"
+	"encoding/binary"
+	"math"
+	"math/bits"
+	"slices"
+
+	"github.com/spaolacci/murmur3"
+)
+
+// A HyperLogLog is kept as a plain string so that it survives GET and SET.
+// The string is a 16 byte header followed by the registers:
+//
+//	"HYLL" | encoding (1 byte) | 3 unused bytes | cached cardinality (8 bytes)
+//
+// The cardinality is little endian and its most significant bit set means it
+// is stale. The dense encoding packs the 16384 registers in 6 bits each. The
+// sparse encoding run-length codes them with the opcodes
+//
+//	00xxxxxx           1 to 64 registers set to 0
+//	01xxxxxx yyyyyyyy  1 to 16384 registers set to 0
+//	1vvvvvxx           1 to 4 registers set to v+1, up to 32
+//
+// which keeps small sets to a few bytes. A sparse HyperLogLog turns dense for
+// good once it grows past the configured size or a register past 32.
+
+const (
+	hllP         = 14
+	hllRegisters = 1 << hllP
+	hllQ         = 64 - hllP
+	hllBits      = 6
+	hllMaxValue  = 1<<hllBits - 1
+
+	hllHeaderSize = 16
+	hllDenseSize  = hllHeaderSize + (hllRegisters*hllBits+7)/8
+
+	hllDense  = 0
+	hllSparse = 1
+
+	hllSparseMaxValue = 32
+	hllSparseMaxZero  = 64
+	hllSparseMaxXZero = 16384
+	hllSparseMaxRun   = 4
+
+	hllSeed       = 0xadc83b19
+	hllStaleCache = 1 << 63
+)
+
+const hllMagic = "HYLL"
+
+// hll is a decoded HyperLogLog.
+type hll struct {
+	registers [hllRegisters]uint8
+	encoding  byte
+	card      uint64
+}
+
+// hllPosition returns the register item counts towards and the value it
+// bids: the position of the first set bit in the rest of the hash.
+func hllPosition(item string) (int, uint8) {
+	h := murmur3.Sum64WithSeed([]byte(item), hllSeed)
+	index := int(h & (hllRegisters - 1))
+	h = h>>hllP | 1<<hllQ
+	return index, uint8(bits.TrailingZeros64(h) + 1)
+}
+
+// hllBytes is an encoded HyperLogLog, read from the stored string or from the
+// buffer PFADD updates.
+type hllBytes interface {
+	~string | ~[]byte
+}
+
+// hllSparseOp decodes the sparse opcode at i of enc: its size in bytes and
+// the run of registers it sets to val. It reports false if it is truncated.
+func hllSparseOp[T hllBytes](enc T, i int) (size, run int, val uint8, ok bool) {
+	op := enc[i]
+	switch {
+	case op&0xc0 == 0x00:
+		return 1, int(op&0x3f) + 1, 0, true
+	case op&0xc0 == 0x40:
+		if i+1 == len(enc) {
+			return 0, 0, 0, false
+		}
+		return 2, int(op&0x3f)<<8 | int(enc[i+1]) + 1, 0, true
+	default:
+		return 1, int(op&0x3) + 1, (op>>2)&0x1f + 1, true
+	}
+}
+
+// hllSparseFind locates the opcode of enc covering register idx: it starts at
+// pos, takes size bytes and sets run registers from start to val.
+func hllSparseFind[T hllBytes](enc T, idx int) (pos, size, start, run int, val uint8, err error) {
+	for pos = hllHeaderSize; pos < len(enc); pos += size {
+		var ok bool
+		if size, run, val, ok = hllSparseOp(enc, pos); !ok {
+			break
+		}
+		if idx < start+run {
+			return pos, size, start, run, val, nil
+		}
+		start += run
+	}
+	return 0, 0, 0, 0, 0, config.ErrHLLInvalid
+}
+
+// hllCheck validates the header of an encoded HyperLogLog.
+func hllCheck(s string) error {
+	if len(s) < hllHeaderSize || s[:4] != hllMagic {
+		return config.ErrHLLInvalid
+	}
+	switch s[4] {
+	case hllDense:
+		if len(s) != hllDenseSize {
+			return config.ErrHLLInvalid
+		}
+	case hllSparse:
+	default:
+		return config.ErrHLLInvalid
+	}
+	return nil
+}
+
+// hllGet reads register idx of enc without decoding the others.
+func hllGet[T hllBytes](enc T, idx int) (uint8, error) {
+	if enc[4] == hllDense {
+		return hllDenseGet(enc, idx), nil
+	}
+	_, _, _, _, val, err := hllSparseFind(enc, idx)
+	return val, err
+}
+
+func decodeHLL(s string) (*hll, error) {
+	if err := hllCheck(s); err != nil {
+		return nil, err
+	}
+	h := &hll{
+		encoding: s[4],
+		card:     binary.LittleEndian.Uint64([]byte(s[8:16])),
+	}
+
+	if h.encoding == hllDense {
+		for i := range h.registers {
+			if h.registers[i] = hllDenseGet(s, i); h.registers[i] > hllQ+1 {
+				return nil, config.ErrHLLInvalid
+			}
+		}
+		return h, nil
+	}
+
+	idx := 0
+	for i := hllHeaderSize; i < len(s); {
+		size, run, val, ok := hllSparseOp(s, i)
+		if !ok || idx+run > hllRegisters {
+			return nil, config.ErrHLLInvalid
+		}
+		for ; run > 0; run-- {
+			h.registers[idx] = val
+			idx++
+		}
+		i += size
+	}
+	if idx != hllRegisters {
+		return nil, config.ErrHLLInvalid
+	}
+	return h, nil
+}
+
+// hllDenseGet reads register i of a dense encoding, header included.
+func hllDenseGet[T hllBytes](enc T, i int) uint8 {
+	bit := hllHeaderSize*8 + i*hllBits
+	b, fb := bit/8, uint(bit%8)
+	v := uint16(enc[b])
+	if b+1 < len(enc) {
+		v |= uint16(enc[b+1]) << 8
+	}
+	return uint8(v>>fb) & hllMaxValue
+}
+
+func hllDenseSet(enc []byte, i int, val uint8) {
+	bit := hllHeaderSize*8 + i*hllBits
+	b, fb := bit/8, uint(bit%8)
+	v := uint16(enc[b])
+	if b+1 < len(enc) {
+		v |= uint16(enc[b+1]) << 8
+	}
+	v = v&^(hllMaxValue<<fb) | uint16(val)<<fb
+	enc[b] = byte(v)
+	if b+1 < len(enc) {
+		enc[b+1] = byte(v >> 8)
+	}
+}
+
+// hllAppendRun appends the sparse opcodes setting run registers to val,
+// which must be at most hllSparseMaxValue.
+func hllAppendRun(out []byte, val uint8, run int) []byte {
+	for run > 0 {
+		if val == 0 {
+			n := min(run, hllSparseMaxXZero)
+			if n <= hllSparseMaxZero {
+				out = append(out, byte(n-1))
+			} else {
+				out = append(out, 0x40|byte((n-1)>>8), byte(n-1))
+			}
+			run -= n
+		} else {
+			n := min(run, hllSparseMaxRun)
+			out = append(out, 0x80|(val-1)<<2|byte(n-1))
+			run -= n
+		}
+	}
+	return out
+}
+
+// encodeSparse run-length codes the registers, or reports false if they
+// cannot be or would take more than maxBytes.
+func (h *hll) encodeSparse(maxBytes int) ([]byte, bool) {
+	var out []byte
+	for i := 0; i < hllRegisters; {
+		val := h.registers[i]
+		run := 1
+		for i+run < hllRegisters && h.registers[i+run] == val {
+			run++
+		}
+		i += run
+
+		if val > hllSparseMaxValue {
+			return nil, false
+		}
+		if out = hllAppendRun(out, val, run); len(out) > maxBytes {
+			return nil, false
+		}
+	}
+	return out, true
+}
+
+// encode renders h, sparse while it may and still fits.
+func (h *hll) encode(sparseMaxBytes int) []byte {
+	var data []byte
+	if h.encoding == hllSparse {
+		var ok bool
+		if data, ok = h.encodeSparse(sparseMaxBytes); !ok {
+			h.encoding = hllDense
+		}
+	}
+
+	var out []byte
+	if h.encoding == hllDense {
+		out = make([]byte, hllDenseSize)
+		for i, val := range h.registers {
+			hllDenseSet(out, i, val)
+		}
+	} else {
+		out = make([]byte, hllHeaderSize, hllHeaderSize+len(data))
+		out = append(out, data...)
+	}
+	copy(out, hllMagic)
+	out[4] = h.encoding
+	binary.LittleEndian.PutUint64(out[8:], h.card)
+	return out
+}
+
+func hllSigma(x float64) float64 {
+	if x == 1 {
+		return math.Inf(1)
+	}
+	y, z := 1.0, x
+	for {
+		x *= x
+		zPrime := z
+		z += x * y
+		y += y
+		if zPrime == z {
+			return z
+		}
+	}
+}
+
+func hllTau(x float64) float64 {
+	if x == 0 || x == 1 {
+		return 0
+	}
+	y, z := 1.0, 1-x
+	for {
+		x = math.Sqrt(x)
+		zPrime := z
+		y *= 0.5
+		z -= (1 - x) * (1 - x) * y
+		if zPrime == z {
+			return z / 3
+		}
+	}
+}
+
+// HLLCardinality estimates the number of distinct items counted by the
+// registers with Ertl's improved raw estimator.
+func HLLCardinality(registers []uint8) uint64 {
+	var histo [hllQ + 2]int
+	for _, r := range registers {
+		histo[r]++
+	}
+
+	m := float64(hllRegisters)
+	z := m * hllTau((m-float64(histo[hllQ+1]))/m)
+	for j := hllQ; j >= 1; j-- {
+		z += float64(histo[j])
+		z *= 0.5
+	}
+	z += m * hllSigma(float64(histo[0])/m)
+	return uint64(math.Round(0.5 / math.Ln2 * m * m / z))
+}
+
+// getHLL decodes the HyperLogLog at key, or returns nil if the key is missing.
+func (s *Datastore) getHLL(key string) (*hll, error) {
+	val, ok, err := s.Get(key)
+	if err != nil || !ok {
+		return nil, err
+	}
+	return decodeHLL(val)
+}
+
+// storeHLL writes h to key, keeping any TTL.
+func (s *Datastore) storeHLL(key string, h *hll) {
+	s.storeHLLBytes(key, h.encode(s.hllSparseMaxBytes))
+}
+
+func (s *Datastore) storeHLLBytes(key string, enc []byte) {
+	e := s.m[key]
+	e.val = string(enc)
+	s.m[key] = e
+}
+
+// hllSet raises register idx of enc to val. A sparse enc is spliced, unless
+// val or the grown opcodes no longer fit it and it turns dense.
+func (s *Datastore) hllSet(enc []byte, idx int, val uint8) ([]byte, error) {
+	if enc[4] == hllDense {
+		hllDenseSet(enc, idx, val)
+		return enc, nil
+	}
+
+	pos, size, start, run, old, err := hllSparseFind(enc, idx)
+	if err != nil {
+		return nil, err
+	}
+	if val <= hllSparseMaxValue {
+		ops := hllAppendRun(nil, old, idx-start)
+		ops = hllAppendRun(ops, val, 1)
+		ops = hllAppendRun(ops, old, start+run-idx-1)
+		if len(enc)-size+len(ops)-hllHeaderSize <= s.hllSparseMaxBytes {
+			return slices.Replace(enc, pos, pos+size, ops...), nil
+		}
+	}
+
+	h, err := decodeHLL(string(enc))
+	if err != nil {
+		return nil, err
+	}
+	h.registers[idx] = val
+	h.encoding = hllDense
+	return h.encode(s.hllSparseMaxBytes), nil
+}
+
+// PFADD
+// It reports whether the key was created or any register changed. Registers
+// are read from the stored string, which is only copied and rewritten once
+// one of them changes.
+func (s *Datastore) PFAdd(key string, items []string) (bool, error) {
+	cur, exists, err := s.Get(key)
+	if err != nil {
+		return false, err
+	}
+	var enc []byte
+	if exists {
+		if err := hllCheck(cur); err != nil {
+			return false, err
+		}
+	} else {
+		enc = (&hll{encoding: hllSparse}).encode(s.hllSparseMaxBytes)
+	}
+
+	for _, item := range items {
+		idx, val := hllPosition(item)
+		var old uint8
+		if enc == nil {
+			old, err = hllGet(cur, idx)
+		} else {
+			old, err = hllGet(enc, idx)
+		}
+		if err != nil {
+			return false, err
+		}
+		if val <= old {
+			continue
+		}
+		if enc == nil {
+			enc = []byte(cur)
+		}
+		if enc, err = s.hllSet(enc, idx, val); err != nil {
+			return false, err
+		}
+	}
+	if enc == nil {
+		return false, nil
+	}
+
+	card := binary.LittleEndian.Uint64(enc[8:16])
+	binary.LittleEndian.PutUint64(enc[8:], card|hllStaleCache)
+	s.storeHLLBytes(key, enc)
+	return true, nil
+}
+
+// PFCOUNT
+// The estimate is cached in the key until the next change.
+func (s *Datastore) PFCount(key string) (uint64, error) {
+	cur, ok, err := s.Get(key)
+	if err != nil || !ok {
+		return 0, err
+	}
+	if err := hllCheck(cur); err != nil {
+		return 0, err
+	}
+	if card := binary.LittleEndian.Uint64([]byte(cur[8:16])); card&hllStaleCache == 0 {
+		return card, nil
+	}
+
+	h, err := decodeHLL(cur)
+	if err != nil {
+		return 0, err
+	}
+	h.card = HLLCardinality(h.registers[:])
+	s.storeHLL(key, h)
+	return h.card, nil
+}
+
+// HLLRegisters returns the registers of the HyperLogLog at key, or nil if the
+// key is missing.
+func (s *Datastore) HLLRegisters(key string) ([]uint8, error) {
+	h, err := s.getHLL(key)
+	if err != nil || h == nil {
+		return nil, err
+	}
+	return h.registers[:], nil
+}
+
+// HLLStore overwrites key with a HyperLogLog of the given registers.
+func (s *Datastore) HLLStore(key string, registers []uint8) {
+	h := &hll{encoding: hllSparse, card: hllStaleCache}
+	copy(h.registers[:], registers)
+	s.storeHLL(key, h)
+}
+
+// HLLNewRegisters returns an empty set of registers.
+func HLLNewRegisters() []uint8 {
+	return make([]uint8, hllRegisters)
+}
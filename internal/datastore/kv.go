@@ -10,6 +10,7 @@ func (s *Datastore) Set(key, val string, ttl time.Duration) {
 	if ttl > 0 {
 		expireAt := time.Now().Add(ttl)
 		e.expireAt = &expireAt
+		s.expires.add(key)
 	}
 	s.m[key] = e
 }
@@ -58,6 +59,7 @@ func (s *Datastore) Expire(key string, seconds int64) bool {
 	expireAt := time.Now().Add(time.Duration(seconds) * time.Second)
 	e.expireAt = &expireAt
 	s.m[key] = e
+	s.expires.add(key)
 	return true
 }
 
@@ -69,6 +71,7 @@ func (s *Datastore) PExpire(key string, ms int64) bool {
 	expireAt := time.Now().Add(time.Duration(ms) * time.Millisecond)
 	e.expireAt = &expireAt
 	s.m[key] = e
+	s.expires.add(key)
 	return true
 }
 
@@ -79,6 +82,7 @@ func (s *Datastore) Persist(key string) bool {
 	}
 	e.expireAt = nil
 	s.m[key] = e
+	s.expires.remove(key)
 	return true
 }
 
@@ -97,6 +101,7 @@ func (s *Datastore) Del(keys []string) int {
 	for _, key := range keys {
 		if _, ok := s.getEntry(key); ok {
 			delete(s.m, key)
+			s.expires.remove(key)
 			count++
 		}
 	}
@@ -0,0 +1,179 @@
+package datastore
+
+import (
+	"backend/internal/config"
+	"container/list"
+)
+
+type EntryList struct {
+	l *list.List
+}
+
+func (s *Datastore) getList(key string) (*EntryList, error) {
+	e, ok := s.getEntry(key)
+	if !ok {
+		return nil, nil
+	}
+	lst, ok := e.val.(*EntryList)
+	if !ok {
+		return nil, config.ErrWrongType
+	}
+	return lst, nil
+}
+
+func (s *Datastore) ensureList(key string) (*EntryList, error) {
+	e, ok := s.m[key]
+	if ok && !s.isExpired(e) {
+		if lst, ok := e.val.(*EntryList); ok {
+			return lst, nil
+		}
+		return nil, config.ErrWrongType
+	}
+
+	newList := &EntryList{l: list.New()}
+	s.m[key] = Entry{val: newList}
+	return newList, nil
+}
+
+// LPush prepends values one by one and returns the new length of the list.
+func (s *Datastore) LPush(key string, values []string) (int, error) {
+	lst, err := s.ensureList(key)
+	if err != nil {
+		return 0, err
+	}
+	for _, v := range values {
+		lst.l.PushFront(v)
+	}
+	return lst.l.Len(), nil
+}
+
+// RPush appends values one by one and returns the new length of the list.
+func (s *Datastore) RPush(key string, values []string) (int, error) {
+	lst, err := s.ensureList(key)
+	if err != nil {
+		return 0, err
+	}
+	for _, v := range values {
+		lst.l.PushBack(v)
+	}
+	return lst.l.Len(), nil
+}
+
+// LPop removes up to count elements from the head of the list.
+// A nil slice means the key does not exist.
+func (s *Datastore) LPop(key string, count int) ([]string, error) {
+	return s.pop(key, count, true)
+}
+
+// RPop removes up to count elements from the tail of the list.
+// A nil slice means the key does not exist.
+func (s *Datastore) RPop(key string, count int) ([]string, error) {
+	return s.pop(key, count, false)
+}
+
+func (s *Datastore) pop(key string, count int, left bool) ([]string, error) {
+	lst, err := s.getList(key)
+	if err != nil {
+		return nil, err
+	}
+	if lst == nil {
+		return nil, nil
+	}
+
+	res := make([]string, 0, min(count, lst.l.Len()))
+	for len(res) < count && lst.l.Len() > 0 {
+		var el *list.Element
+		if left {
+			el = lst.l.Front()
+		} else {
+			el = lst.l.Back()
+		}
+		res = append(res, lst.l.Remove(el).(string))
+	}
+
+	if lst.l.Len() == 0 {
+		delete(s.m, key)
+	}
+	return res, nil
+}
+
+func (s *Datastore) LLen(key string) (int, error) {
+	lst, err := s.getList(key)
+	if err != nil {
+		return 0, err
+	}
+	if lst == nil {
+		return 0, nil
+	}
+	return lst.l.Len(), nil
+}
+
+func (s *Datastore) LRange(key string, start, stop int) ([]string, error) {
+	lst, err := s.getList(key)
+	if err != nil {
+		return nil, err
+	}
+	if lst == nil {
+		return []string{}, nil
+	}
+
+	n := lst.l.Len()
+	if start < 0 {
+		start += n
+	}
+	if stop < 0 {
+		stop += n
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= n {
+		stop = n - 1
+	}
+	if start > stop {
+		return []string{}, nil
+	}
+
+	res := make([]string, 0, stop-start+1)
+	el := lst.l.Front()
+	for i := 0; i < start; i++ {
+		el = el.Next()
+	}
+	for i := start; i <= stop; i++ {
+		res = append(res, el.Value.(string))
+		el = el.Next()
+	}
+	return res, nil
+}
+
+// LMove atomically pops an element from one end of src and pushes it onto one end of dst.
+// The boolean result is false when src does not exist.
+func (s *Datastore) LMove(src, dst string, fromLeft, toLeft bool) (string, bool, error) {
+	srcList, err := s.getList(src)
+	if err != nil {
+		return "", false, err
+	}
+	if srcList == nil {
+		return "", false, nil
+	}
+	// check the destination type before touching the source
+	if _, err := s.getList(dst); err != nil {
+		return "", false, err
+	}
+
+	popped, err := s.pop(src, 1, fromLeft)
+	if err != nil {
+		return "", false, err
+	}
+	val := popped[0]
+
+	if toLeft {
+		_, err = s.LPush(dst, []string{val})
+	} else {
+		_, err = s.RPush(dst, []string{val})
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return val, true, nil
+}
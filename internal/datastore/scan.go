@@ -0,0 +1,74 @@
+package datastore
+
+import (
+	"math"
+	"path"
+	"sort"
+)
+
+// scanMatch reports whether member matches the MATCH pattern, if any.
+func scanMatch(pattern, member string) bool {
+	if pattern == "" {
+		return true
+	}
+	matched, err := path.Match(pattern, member)
+	return err == nil && matched
+}
+
+// scanIndex implements the SCAN cursor contract for a large unordered
+// collection. Members are visited in order of their hash and the cursor is
+// the hash to resume from, so a member present during the whole scan is
+// returned at least once however the collection changes between calls.
+// Members sharing a hash are always returned together. A returned cursor of
+// 0 ends the scan.
+//
+// The index is a snapshot of the members sorted by hash, taken when a scan
+// starts or finds none, that later calls seek into. Any snapshot taken after
+// a scan started holds every member present during the whole of it, so scans
+// running at the same time may share and retake it. Members removed since
+// are skipped by the caller.
+type scanIndex struct {
+	hashes  []uint32
+	members []string
+}
+
+func newScanIndex(each func(fn func(member string))) *scanIndex {
+	type hashed struct {
+		hash   uint32
+		member string
+	}
+	var all []hashed
+	each(func(member string) {
+		all = append(all, hashed{hash: calcHash(member, 0), member: member})
+	})
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].hash < all[j].hash
+	})
+
+	x := &scanIndex{
+		hashes:  make([]uint32, len(all)),
+		members: make([]string, len(all)),
+	}
+	for i, h := range all {
+		x.hashes[i], x.members[i] = h.hash, h.member
+	}
+	return x
+}
+
+// scan returns count members from cursor on, more to keep those sharing a
+// hash together, and the cursor to resume from.
+func (x *scanIndex) scan(cursor uint64, count int) (uint64, []string) {
+	lo := sort.Search(len(x.hashes), func(i int) bool {
+		return uint64(x.hashes[i]) >= cursor
+	})
+	hi := min(lo+max(count, 1), len(x.hashes))
+	for hi > lo && hi < len(x.hashes) && x.hashes[hi] == x.hashes[hi-1] {
+		hi++
+	}
+
+	var next uint64
+	if hi < len(x.hashes) && x.hashes[hi-1] < math.MaxUint32 {
+		next = uint64(x.hashes[hi-1]) + 1
+	}
+	return next, x.members[lo:hi]
+}
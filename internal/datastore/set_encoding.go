@@ -0,0 +1,232 @@
+package datastore
+
+import (
+	"encoding/binary"
+	"sort"
+	"strconv"
+)
+
+// A set starts in the most compact encoding its members allow and is
+// converted, never back, once it outgrows it:
+//
+//	intset    sorted []int64, while every member is a canonical integer
+//	listpack  members packed back to back in one []byte, while the set is small
+//	hashtable map[string]struct{}, past the limits above
+type setEncoding uint8
+
+const (
+	setEncIntset setEncoding = iota
+	setEncListpack
+	setEncHashtable
+)
+
+func (enc setEncoding) String() string {
+	switch enc {
+	case setEncIntset:
+		return "intset"
+	case setEncListpack:
+		return "listpack"
+	}
+	return "hashtable"
+}
+
+type setLimits struct {
+	maxIntsetEntries   int
+	maxListpackEntries int
+	maxListpackValue   int
+}
+
+func newSimpleSet() *EntrySimpleSet {
+	return &EntrySimpleSet{enc: setEncIntset}
+}
+
+// parseSetInt reports whether member is an integer whose decimal form is
+// exactly member, so that it can be stored in an intset and printed back.
+func parseSetInt(member string) (int64, bool) {
+	v, err := strconv.ParseInt(member, 10, 64)
+	if err != nil || strconv.FormatInt(v, 10) != member {
+		return 0, false
+	}
+	return v, true
+}
+
+// listpack entries are a uvarint length followed by the member bytes.
+func lpNext(lp []byte, off int) (string, int) {
+	n, size := binary.Uvarint(lp[off:])
+	start := off + size
+	end := start + int(n)
+	return string(lp[start:end]), end
+}
+
+// lpAt returns the offsets where the i-th member starts and ends.
+func lpAt(lp []byte, i int) (int, int) {
+	off := 0
+	for ; i > 0; i-- {
+		_, off = lpNext(lp, off)
+	}
+	_, end := lpNext(lp, off)
+	return off, end
+}
+
+func lpAppend(lp []byte, member string) []byte {
+	lp = binary.AppendUvarint(lp, uint64(len(member)))
+	return append(lp, member...)
+}
+
+// lpFind returns the offsets where member starts and ends, or -1.
+func lpFind(lp []byte, member string) (int, int) {
+	for off := 0; off < len(lp); {
+		m, next := lpNext(lp, off)
+		if m == member {
+			return off, next
+		}
+		off = next
+	}
+	return -1, -1
+}
+
+func (set *EntrySimpleSet) len() int {
+	switch set.enc {
+	case setEncIntset:
+		return len(set.intset)
+	case setEncListpack:
+		return set.lpCount
+	}
+	return len(set.mapVal)
+}
+
+func (set *EntrySimpleSet) has(member string) bool {
+	switch set.enc {
+	case setEncIntset:
+		v, ok := parseSetInt(member)
+		if !ok {
+			return false
+		}
+		i := sort.Search(len(set.intset), func(i int) bool { return set.intset[i] >= v })
+		return i < len(set.intset) && set.intset[i] == v
+	case setEncListpack:
+		off, _ := lpFind(set.listpack, member)
+		return off >= 0
+	}
+	_, ok := set.mapVal[member]
+	return ok
+}
+
+// each calls fn for every member until fn returns false.
+func (set *EntrySimpleSet) each(fn func(member string) bool) {
+	switch set.enc {
+	case setEncIntset:
+		for _, v := range set.intset {
+			if !fn(strconv.FormatInt(v, 10)) {
+				return
+			}
+		}
+	case setEncListpack:
+		for off := 0; off < len(set.listpack); {
+			var m string
+			m, off = lpNext(set.listpack, off)
+			if !fn(m) {
+				return
+			}
+		}
+	default:
+		for m := range set.mapVal {
+			if !fn(m) {
+				return
+			}
+		}
+	}
+}
+
+func (set *EntrySimpleSet) members() []string {
+	res := make([]string, 0, set.len())
+	set.each(func(m string) bool {
+		res = append(res, m)
+		return true
+	})
+	return res
+}
+
+// add inserts member, converting the encoding first if member would not fit.
+func (set *EntrySimpleSet) add(member string, limits setLimits) bool {
+	if set.has(member) {
+		return false
+	}
+
+	if set.enc == setEncIntset {
+		v, ok := parseSetInt(member)
+		if ok && len(set.intset) < limits.maxIntsetEntries {
+			i := sort.Search(len(set.intset), func(i int) bool { return set.intset[i] >= v })
+			set.intset = append(set.intset, 0)
+			copy(set.intset[i+1:], set.intset[i:])
+			set.intset[i] = v
+			return true
+		}
+		// integers never exceed the listpack value limit, only the new member can
+		if set.len() < limits.maxListpackEntries && len(member) <= limits.maxListpackValue {
+			set.convert(setEncListpack)
+		} else {
+			set.convert(setEncHashtable)
+		}
+	}
+
+	if set.enc == setEncListpack {
+		if set.lpCount < limits.maxListpackEntries && len(member) <= limits.maxListpackValue {
+			set.listpack = lpAppend(set.listpack, member)
+			set.lpCount++
+			return true
+		}
+		set.convert(setEncHashtable)
+	}
+
+	set.mapVal[member] = struct{}{}
+	return true
+}
+
+func (set *EntrySimpleSet) remove(member string) bool {
+	switch set.enc {
+	case setEncIntset:
+		v, ok := parseSetInt(member)
+		if !ok {
+			return false
+		}
+		i := sort.Search(len(set.intset), func(i int) bool { return set.intset[i] >= v })
+		if i == len(set.intset) || set.intset[i] != v {
+			return false
+		}
+		set.intset = append(set.intset[:i], set.intset[i+1:]...)
+		return true
+	case setEncListpack:
+		start, end := lpFind(set.listpack, member)
+		if start < 0 {
+			return false
+		}
+		set.listpack = append(set.listpack[:start], set.listpack[end:]...)
+		set.lpCount--
+		return true
+	}
+	if _, ok := set.mapVal[member]; !ok {
+		return false
+	}
+	delete(set.mapVal, member)
+	return true
+}
+
+func (set *EntrySimpleSet) convert(enc setEncoding) {
+	members := set.members()
+	set.intset, set.listpack, set.lpCount = nil, nil, 0
+
+	switch enc {
+	case setEncListpack:
+		for _, m := range members {
+			set.listpack = lpAppend(set.listpack, m)
+		}
+		set.lpCount = len(members)
+	case setEncHashtable:
+		set.mapVal = make(map[string]struct{}, len(members))
+		for _, m := range members {
+			set.mapVal[m] = struct{}{}
+		}
+	}
+	set.enc = enc
+}
@@ -1,9 +1,19 @@
 package datastore
 
-import "backend/internal/config"
+import (
+	"backend/internal/config"
+	"math/rand"
+	"strconv"
+)
 
 type EntrySimpleSet struct {
-	mapVal map[string]struct{}
+	enc      setEncoding
+	intset   []int64
+	listpack []byte
+	lpCount  int
+	mapVal   map[string]struct{}
+	// scan is the SSCAN snapshot of a hashtable, see scanIndex
+	scan *scanIndex
 }
 
 func (s *Datastore) getSimpleSet(key string) (*EntrySimpleSet, error) {
@@ -21,8 +31,7 @@ func (s *Datastore) getSimpleSet(key string) (*EntrySimpleSet, error) {
 func (s *Datastore) SADD(key string, members []string) (int, error) {
 	e, ok := s.getEntry(key)
 	if !ok {
-		set := &EntrySimpleSet{mapVal: make(map[string]struct{})}
-		s.m[key] = Entry{val: set}
+		s.m[key] = Entry{val: newSimpleSet()}
 		e = s.m[key]
 	}
 
@@ -33,8 +42,7 @@ func (s *Datastore) SADD(key string, members []string) (int, error) {
 
 	countAdded := 0
 	for _, m := range members {
-		if _, exists := set.mapVal[m]; !exists {
-			set.mapVal[m] = struct{}{}
+		if set.add(m, s.setLimits) {
 			countAdded++
 		}
 	}
@@ -50,11 +58,7 @@ func (s *Datastore) SMembers(key string) ([]string, error) {
 		return []string{}, nil
 	}
 
-	members := make([]string, 0, len(set.mapVal))
-	for k := range set.mapVal {
-		members = append(members, k)
-	}
-	return members, nil
+	return set.members(), nil
 }
 
 // SIsMember checks if a member exists in the set.
@@ -67,7 +71,7 @@ func (s *Datastore) SIsMember(key, member string) (int, error) {
 		return 0, nil
 	}
 
-	if _, exists := set.mapVal[member]; exists {
+	if set.has(member) {
 		return 1, nil
 	}
 	return 0, nil
@@ -86,9 +90,237 @@ func (s *Datastore) SMIsMember(key string, members []string) ([]int, error) {
 	}
 
 	for i, m := range members {
-		if _, exists := set.mapVal[m]; exists {
+		if set.has(m) {
 			results[i] = 1
 		}
 	}
 	return results, nil
 }
+
+// SRem removes members and deletes the key once the set is empty.
+func (s *Datastore) SRem(key string, members []string) (int, error) {
+	set, err := s.getSimpleSet(key)
+	if err != nil {
+		return 0, err
+	}
+	if set == nil {
+		return 0, nil
+	}
+
+	countRemoved := 0
+	for _, m := range members {
+		if set.remove(m) {
+			countRemoved++
+		}
+	}
+	if set.len() == 0 {
+		delete(s.m, key)
+	}
+	return countRemoved, nil
+}
+
+func (s *Datastore) SCard(key string) (int, error) {
+	set, err := s.getSimpleSet(key)
+	if err != nil {
+		return 0, err
+	}
+	if set == nil {
+		return 0, nil
+	}
+	return set.len(), nil
+}
+
+// sampleMembers returns count distinct members picked uniformly at random,
+// or every member when count covers the whole set.
+func (set *EntrySimpleSet) sampleMembers(count int) []string {
+	if count >= set.len() {
+		return set.members()
+	}
+
+	// reservoir sampling: a single pass without materializing the set
+	res := make([]string, 0, count)
+	seen := 0
+	set.each(func(m string) bool {
+		if seen < count {
+			res = append(res, m)
+		} else if j := rand.Intn(seen + 1); j < count {
+			res[j] = m
+		}
+		seen++
+		return true
+	})
+	return res
+}
+
+// spopSampleRatio is how small a fraction of a hashtable SPOP takes straight
+// from map iteration rather than by sampling the whole set.
+const spopSampleRatio = 5
+
+// popMembers removes and returns count distinct members picked at random, or
+// every member when count covers the whole set. Intsets and listpacks pop
+// random indexes. A hashtable pops the first members map iteration yields,
+// which starts at a random position, unless count is a large fraction of it.
+func (set *EntrySimpleSet) popMembers(count int) []string {
+	if count >= set.len() {
+		res := set.members()
+		*set = *newSimpleSet()
+		return res
+	}
+
+	res := make([]string, 0, count)
+	switch set.enc {
+	case setEncIntset:
+		for range count {
+			i := rand.Intn(len(set.intset))
+			res = append(res, strconv.FormatInt(set.intset[i], 10))
+			set.intset = append(set.intset[:i], set.intset[i+1:]...)
+		}
+	case setEncListpack:
+		for range count {
+			start, end := lpAt(set.listpack, rand.Intn(set.lpCount))
+			m, _ := lpNext(set.listpack, start)
+			res = append(res, m)
+			set.listpack = append(set.listpack[:start], set.listpack[end:]...)
+			set.lpCount--
+		}
+	default:
+		if count*spopSampleRatio < len(set.mapVal) {
+			for m := range set.mapVal {
+				delete(set.mapVal, m)
+				if res = append(res, m); len(res) == count {
+					break
+				}
+			}
+		} else {
+			res = set.sampleMembers(count)
+			for _, m := range res {
+				delete(set.mapVal, m)
+			}
+		}
+	}
+	return res
+}
+
+// SPop removes and returns up to count random members.
+// A nil slice means the key does not exist.
+func (s *Datastore) SPop(key string, count int) ([]string, error) {
+	set, err := s.getSimpleSet(key)
+	if err != nil {
+		return nil, err
+	}
+	if set == nil {
+		return nil, nil
+	}
+
+	res := set.popMembers(count)
+	if set.len() == 0 {
+		delete(s.m, key)
+	}
+	return res, nil
+}
+
+// SRandMember returns random members without removing them. A positive count
+// returns distinct members, a negative one returns -count members that may repeat.
+// A nil slice means the key does not exist.
+func (s *Datastore) SRandMember(key string, count int) ([]string, error) {
+	set, err := s.getSimpleSet(key)
+	if err != nil {
+		return nil, err
+	}
+	if set == nil {
+		return nil, nil
+	}
+
+	if count >= 0 {
+		return set.sampleMembers(count), nil
+	}
+
+	members := set.members()
+	var res []string
+	for i := 0; i < -count; i++ {
+		res = append(res, members[rand.Intn(len(members))])
+	}
+	return res, nil
+}
+
+// SMove moves member from src to dst and reports whether it was moved.
+func (s *Datastore) SMove(src, dst, member string) (int, error) {
+	srcSet, err := s.getSimpleSet(src)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := s.getSimpleSet(dst); err != nil {
+		return 0, err
+	}
+	if srcSet == nil {
+		return 0, nil
+	}
+	if !srcSet.has(member) {
+		return 0, nil
+	}
+	if src == dst {
+		return 1, nil
+	}
+
+	if _, err := s.SRem(src, []string{member}); err != nil {
+		return 0, err
+	}
+	if _, err := s.SADD(dst, []string{member}); err != nil {
+		return 0, err
+	}
+	return 1, nil
+}
+
+// SScan iterates the set with a cursor, see scanIndex. An intset or listpack
+// is small enough to be returned whole in one call.
+func (s *Datastore) SScan(key string, cursor uint64, pattern string, count int) (uint64, []string, error) {
+	set, err := s.getSimpleSet(key)
+	if err != nil {
+		return 0, nil, err
+	}
+	if set == nil {
+		return 0, []string{}, nil
+	}
+
+	if set.enc != setEncHashtable {
+		res := []string{}
+		set.each(func(m string) bool {
+			if scanMatch(pattern, m) {
+				res = append(res, m)
+			}
+			return true
+		})
+		return 0, res, nil
+	}
+
+	if cursor == 0 || set.scan == nil {
+		set.scan = newScanIndex(func(fn func(string)) {
+			for m := range set.mapVal {
+				fn(m)
+			}
+		})
+	}
+	next, members := set.scan.scan(cursor, count)
+	if next == 0 {
+		set.scan = nil
+	}
+
+	res := make([]string, 0, len(members))
+	for _, m := range members {
+		if set.has(m) && scanMatch(pattern, m) {
+			res = append(res, m)
+		}
+	}
+	return next, res, nil
+}
+
+// SStore replaces whatever key holds with a set of members, or deletes the
+// key when there are none. It returns the cardinality of the stored set.
+func (s *Datastore) SStore(key string, members []string) int {
+	delete(s.m, key)
+	if len(members) == 0 {
+		return 0
+	}
+	n, _ := s.SADD(key, members)
+	return n
+}
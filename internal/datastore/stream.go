@@ -0,0 +1,856 @@
+package datastore
+
+import (
+	"backend/internal/config"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type streamID struct {
+	ms  uint64
+	seq uint64
+}
+
+func (id streamID) less(other streamID) bool {
+	if id.ms != other.ms {
+		return id.ms < other.ms
+	}
+	return id.seq < other.seq
+}
+
+func (id streamID) equal(other streamID) bool {
+	return id == other
+}
+
+func (id streamID) String() string {
+	return strconv.FormatUint(id.ms, 10) + "-" + strconv.FormatUint(id.seq, 10)
+}
+
+var maxStreamID = streamID{ms: math.MaxUint64, seq: math.MaxUint64}
+
+// next returns the smallest ID greater than id.
+func (id streamID) next() (streamID, bool) {
+	if id.seq < math.MaxUint64 {
+		return streamID{ms: id.ms, seq: id.seq + 1}, true
+	}
+	if id.ms < math.MaxUint64 {
+		return streamID{ms: id.ms + 1}, true
+	}
+	return id, false
+}
+
+// prev returns the greatest ID smaller than id.
+func (id streamID) prev() (streamID, bool) {
+	if id.seq > 0 {
+		return streamID{ms: id.ms, seq: id.seq - 1}, true
+	}
+	if id.ms > 0 {
+		return streamID{ms: id.ms - 1, seq: math.MaxUint64}, true
+	}
+	return id, false
+}
+
+// parseStreamID parses "ms-seq" or "ms", in which case seq defaults to missingSeq.
+func parseStreamID(s string, missingSeq uint64) (streamID, error) {
+	msPart, seqPart, hasSeq := strings.Cut(s, "-")
+	ms, err := strconv.ParseUint(msPart, 10, 64)
+	if err != nil {
+		return streamID{}, config.ErrStreamInvalidID
+	}
+	if !hasSeq {
+		return streamID{ms: ms, seq: missingSeq}, nil
+	}
+	seq, err := strconv.ParseUint(seqPart, 10, 64)
+	if err != nil {
+		return streamID{}, config.ErrStreamInvalidID
+	}
+	return streamID{ms: ms, seq: seq}, nil
+}
+
+// parseRangeID parses an XRANGE style bound: "-", "+", an ID, or "(" followed
+// by an ID for an exclusive bound. The boolean is false for an empty range.
+func parseRangeID(s string, isEnd bool) (streamID, bool, error) {
+	switch s {
+	case "-":
+		return streamID{}, true, nil
+	case "+":
+		return maxStreamID, true, nil
+	}
+
+	exclusive := strings.HasPrefix(s, "(")
+	if exclusive {
+		s = s[1:]
+	}
+	var missingSeq uint64
+	if isEnd {
+		missingSeq = math.MaxUint64
+	}
+	id, err := parseStreamID(s, missingSeq)
+	if err != nil {
+		return streamID{}, false, err
+	}
+	if !exclusive {
+		return id, true, nil
+	}
+	if isEnd {
+		id, ok := id.prev()
+		return id, ok, nil
+	}
+	id, ok := id.next()
+	return id, ok, nil
+}
+
+type streamPending struct {
+	consumer      *streamConsumer
+	deliveryTime  time.Time
+	deliveryCount int
+}
+
+type streamConsumer struct {
+	name     string
+	seenTime time.Time
+	pending  *bptree[streamID]
+}
+
+type streamGroup struct {
+	lastID    streamID
+	pel       map[streamID]*streamPending
+	pelIndex  *bptree[streamID]
+	consumers map[string]*streamConsumer
+}
+
+type EntryStream struct {
+	entries map[streamID][]string // id -> field value pairs
+	index   *bptree[streamID]
+	lastID  streamID
+	groups  map[string]*streamGroup
+}
+
+// StreamEntry is a stream entry as returned to clients.
+// Fields is nil when the entry was deleted while still pending.
+type StreamEntry struct {
+	ID     string
+	Fields []string
+}
+
+// StreamPendingEntry describes one entry of a consumer group pending list.
+type StreamPendingEntry struct {
+	ID            string
+	Consumer      string
+	Idle          int64
+	DeliveryCount int
+}
+
+// StreamTrim holds the MAXLEN / MINID trimming options of XADD and XTRIM.
+type StreamTrim struct {
+	Strategy string // "MAXLEN", "MINID", or empty for no trimming
+	MaxLen   int
+	MinID    string
+	Limit    int // maximum number of evicted entries, 0 means no limit
+}
+
+// StreamClaimOptions holds the optional arguments of XCLAIM.
+type StreamClaimOptions struct {
+	Idle       int64 // -1 if not set
+	Time       int64 // unix ms, -1 if not set
+	RetryCount int   // -1 if not set
+	Force      bool
+	JustID     bool
+	LastID     string
+}
+
+func newEntryStream() *EntryStream {
+	return &EntryStream{
+		entries: make(map[streamID][]string),
+		index:   newBPTree[streamID](),
+		groups:  make(map[string]*streamGroup),
+	}
+}
+
+func (st *EntryStream) entry(id streamID) StreamEntry {
+	return StreamEntry{ID: id.String(), Fields: st.entries[id]}
+}
+
+func (st *EntryStream) remove(id streamID) bool {
+	if _, ok := st.entries[id]; !ok {
+		return false
+	}
+	delete(st.entries, id)
+	st.index.delete(id)
+	return true
+}
+
+// rangeEntries returns up to count entries between start and end (inclusive),
+// in descending order when rev is set. A count <= 0 means no limit.
+func (st *EntryStream) rangeEntries(start, end streamID, count int, rev bool) []StreamEntry {
+	res := []StreamEntry{}
+	if end.less(start) {
+		return res
+	}
+	collect := func(id streamID) bool {
+		if (!rev && end.less(id)) || (rev && id.less(start)) {
+			return false
+		}
+		res = append(res, st.entry(id))
+		return count <= 0 || len(res) < count
+	}
+	if rev {
+		st.index.descend(end, collect)
+	} else {
+		st.index.ascend(start, collect)
+	}
+	return res
+}
+
+func (st *EntryStream) trim(trim StreamTrim) (int, error) {
+	var minID streamID
+	if trim.Strategy == "MINID" {
+		id, err := parseStreamID(trim.MinID, 0)
+		if err != nil {
+			return 0, err
+		}
+		minID = id
+	}
+
+	evicted := 0
+	for st.index.size > 0 && (trim.Limit <= 0 || evicted < trim.Limit) {
+		first := st.index.rangeByRank(0, 0)[0]
+		if trim.Strategy == "MAXLEN" && st.index.size <= trim.MaxLen {
+			break
+		}
+		if trim.Strategy == "MINID" && !first.less(minID) {
+			break
+		}
+		st.remove(first)
+		evicted++
+	}
+	return evicted, nil
+}
+
+func (s *Datastore) getStream(key string) (*EntryStream, error) {
+	e, ok := s.getEntry(key)
+	if !ok {
+		return nil, nil
+	}
+	st, ok := e.val.(*EntryStream)
+	if !ok {
+		return nil, config.ErrWrongType
+	}
+	return st, nil
+}
+
+func (s *Datastore) ensureStream(key string) (*EntryStream, error) {
+	e, ok := s.m[key]
+	if ok && !s.isExpired(e) {
+		if st, ok := e.val.(*EntryStream); ok {
+			return st, nil
+		}
+		return nil, config.ErrWrongType
+	}
+
+	st := newEntryStream()
+	s.m[key] = Entry{val: st}
+	return st, nil
+}
+
+func (s *Datastore) getStreamGroup(key, group string) (*EntryStream, *streamGroup, error) {
+	st, err := s.getStream(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if st == nil {
+		return nil, nil, config.ErrStreamNoGroup
+	}
+	g, ok := st.groups[group]
+	if !ok {
+		return nil, nil, config.ErrStreamNoGroup
+	}
+	return st, g, nil
+}
+
+// nextStreamID computes the ID of a new entry from an XADD ID argument,
+// which is "*", "ms-*" or an explicit "ms-seq".
+func nextStreamID(last streamID, arg string) (streamID, error) {
+	if arg == "*" {
+		ms := uint64(time.Now().UnixMilli())
+		if ms > last.ms {
+			return streamID{ms: ms}, nil
+		}
+		id, ok := last.next()
+		if !ok {
+			return streamID{}, config.ErrStreamIDTooSmall
+		}
+		return id, nil
+	}
+
+	if msPart, ok := strings.CutSuffix(arg, "-*"); ok {
+		ms, err := strconv.ParseUint(msPart, 10, 64)
+		if err != nil {
+			return streamID{}, config.ErrStreamInvalidID
+		}
+		if ms < last.ms || (ms == last.ms && last.seq == math.MaxUint64) {
+			return streamID{}, config.ErrStreamIDTooSmall
+		}
+		if ms == last.ms {
+			return streamID{ms: ms, seq: last.seq + 1}, nil
+		}
+		return streamID{ms: ms}, nil
+	}
+
+	id, err := parseStreamID(arg, 0)
+	if err != nil {
+		return streamID{}, err
+	}
+	if id == (streamID{}) {
+		return streamID{}, config.ErrStreamIDZero
+	}
+	if !last.less(id) {
+		return streamID{}, config.ErrStreamIDTooSmall
+	}
+	return id, nil
+}
+
+// XAdd appends an entry and returns its ID. The boolean is false when the
+// stream does not exist and noMkStream is set.
+func (s *Datastore) XAdd(key, id string, fields []string, noMkStream bool, trim StreamTrim) (string, bool, error) {
+	st, err := s.getStream(key)
+	if err != nil {
+		return "", false, err
+	}
+	if st == nil && noMkStream {
+		return "", false, nil
+	}
+
+	var last streamID
+	if st != nil {
+		last = st.lastID
+	}
+	newID, err := nextStreamID(last, id)
+	if err != nil {
+		return "", false, err
+	}
+
+	if st == nil {
+		st, err = s.ensureStream(key)
+		if err != nil {
+			return "", false, err
+		}
+	}
+	st.entries[newID] = append([]string(nil), fields...)
+	st.index.insert(newID)
+	st.lastID = newID
+
+	if trim.Strategy != "" {
+		if _, err := st.trim(trim); err != nil {
+			return "", false, err
+		}
+	}
+	return newID.String(), true, nil
+}
+
+func (s *Datastore) XLen(key string) (int, error) {
+	st, err := s.getStream(key)
+	if err != nil {
+		return 0, err
+	}
+	if st == nil {
+		return 0, nil
+	}
+	return st.index.size, nil
+}
+
+// XRange returns entries between start and end, in reverse order when rev is set.
+// For XREVRANGE callers still pass the lower bound as start.
+func (s *Datastore) XRange(key, start, end string, count int, rev bool) ([]StreamEntry, error) {
+	from, okFrom, err := parseRangeID(start, false)
+	if err != nil {
+		return nil, err
+	}
+	to, okTo, err := parseRangeID(end, true)
+	if err != nil {
+		return nil, err
+	}
+
+	st, err := s.getStream(key)
+	if err != nil {
+		return nil, err
+	}
+	if st == nil || !okFrom || !okTo {
+		return []StreamEntry{}, nil
+	}
+	return st.rangeEntries(from, to, count, rev), nil
+}
+
+func (s *Datastore) XDel(key string, ids []string) (int, error) {
+	parsed := make([]streamID, len(ids))
+	for i, id := range ids {
+		p, err := parseStreamID(id, 0)
+		if err != nil {
+			return 0, err
+		}
+		parsed[i] = p
+	}
+
+	st, err := s.getStream(key)
+	if err != nil {
+		return 0, err
+	}
+	if st == nil {
+		return 0, nil
+	}
+
+	deleted := 0
+	for _, id := range parsed {
+		if st.remove(id) {
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+func (s *Datastore) XTrim(key string, trim StreamTrim) (int, error) {
+	st, err := s.getStream(key)
+	if err != nil {
+		return 0, err
+	}
+	if st == nil {
+		return 0, nil
+	}
+	return st.trim(trim)
+}
+
+// XLastID returns the ID of the last entry ever added to the stream, which is
+// what "$" stands for in XREAD and XGROUP.
+func (s *Datastore) XLastID(key string) (string, error) {
+	st, err := s.getStream(key)
+	if err != nil {
+		return "", err
+	}
+	if st == nil {
+		return streamID{}.String(), nil
+	}
+	return st.lastID.String(), nil
+}
+
+// XRead returns up to count entries with an ID greater than after.
+func (s *Datastore) XRead(key, after string, count int) ([]StreamEntry, error) {
+	id, err := parseStreamID(after, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	st, err := s.getStream(key)
+	if err != nil {
+		return nil, err
+	}
+	if st == nil {
+		return nil, nil
+	}
+	from, ok := id.next()
+	if !ok {
+		return nil, nil
+	}
+	return st.rangeEntries(from, maxStreamID, count, false), nil
+}
+
+func (s *Datastore) XGroupCreate(key, group, id string, mkStream bool) error {
+	st, err := s.getStream(key)
+	if err != nil {
+		return err
+	}
+	if st == nil {
+		if !mkStream {
+			return config.ErrStreamGroupNeedsKey
+		}
+		if st, err = s.ensureStream(key); err != nil {
+			return err
+		}
+	}
+	if _, ok := st.groups[group]; ok {
+		return config.ErrStreamBusyGroup
+	}
+
+	lastID := st.lastID
+	if id != "$" {
+		if lastID, err = parseStreamID(id, 0); err != nil {
+			return err
+		}
+	}
+	st.groups[group] = &streamGroup{
+		lastID:    lastID,
+		pel:       make(map[streamID]*streamPending),
+		pelIndex:  newBPTree[streamID](),
+		consumers: make(map[string]*streamConsumer),
+	}
+	return nil
+}
+
+func (s *Datastore) XGroupDestroy(key, group string) (int, error) {
+	st, err := s.getStream(key)
+	if err != nil {
+		return 0, err
+	}
+	if st == nil {
+		return 0, config.ErrStreamGroupNeedsKey
+	}
+	if _, ok := st.groups[group]; !ok {
+		return 0, nil
+	}
+	delete(st.groups, group)
+	return 1, nil
+}
+
+func (g *streamGroup) consumer(name string) (*streamConsumer, bool) {
+	if c, ok := g.consumers[name]; ok {
+		return c, false
+	}
+	c := &streamConsumer{
+		name:     name,
+		seenTime: time.Now(),
+		pending:  newBPTree[streamID](),
+	}
+	g.consumers[name] = c
+	return c, true
+}
+
+func (g *streamGroup) ack(id streamID) bool {
+	p, ok := g.pel[id]
+	if !ok {
+		return false
+	}
+	delete(g.pel, id)
+	g.pelIndex.delete(id)
+	p.consumer.pending.delete(id)
+	return true
+}
+
+// assign makes c the owner of the pending entry id, creating it if needed.
+func (g *streamGroup) assign(id streamID, c *streamConsumer) *streamPending {
+	p, ok := g.pel[id]
+	if !ok {
+		p = &streamPending{}
+		g.pel[id] = p
+		g.pelIndex.insert(id)
+	} else if p.consumer != c {
+		p.consumer.pending.delete(id)
+	}
+	p.consumer = c
+	c.pending.insert(id)
+	return p
+}
+
+// XGroupCreateConsumer returns 1 if the consumer was created, 0 if it already existed.
+func (s *Datastore) XGroupCreateConsumer(key, group, consumer string) (int, error) {
+	_, g, err := s.getStreamGroup(key, group)
+	if err != nil {
+		return 0, err
+	}
+	if _, created := g.consumer(consumer); created {
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// XGroupDelConsumer deletes a consumer and returns how many pending entries it had.
+func (s *Datastore) XGroupDelConsumer(key, group, consumer string) (int, error) {
+	_, g, err := s.getStreamGroup(key, group)
+	if err != nil {
+		return 0, err
+	}
+	c, ok := g.consumers[consumer]
+	if !ok {
+		return 0, nil
+	}
+
+	pending := c.pending.rangeByRank(0, -1)
+	for _, id := range pending {
+		g.ack(id)
+	}
+	delete(g.consumers, consumer)
+	return len(pending), nil
+}
+
+// XReadGroup reads on behalf of a consumer. With id ">" it delivers entries
+// never delivered to the group; otherwise it replays the consumer's own
+// pending entries with an ID greater than id.
+func (s *Datastore) XReadGroup(key, group, consumer, id string, count int, noAck bool) ([]StreamEntry, error) {
+	var after streamID
+	if id != ">" {
+		var err error
+		if after, err = parseStreamID(id, 0); err != nil {
+			return nil, err
+		}
+	}
+
+	st, g, err := s.getStreamGroup(key, group)
+	if err != nil {
+		return nil, err
+	}
+	c, _ := g.consumer(consumer)
+	now := time.Now()
+	c.seenTime = now
+
+	res := []StreamEntry{}
+	from, ok := after.next()
+	if !ok {
+		return res, nil
+	}
+
+	if id != ">" {
+		c.pending.ascend(from, func(pid streamID) bool {
+			p := g.pel[pid]
+			p.deliveryTime = now
+			p.deliveryCount++
+			res = append(res, st.entry(pid))
+			return count <= 0 || len(res) < count
+		})
+		return res, nil
+	}
+
+	if from, ok = g.lastID.next(); !ok {
+		return res, nil
+	}
+	for _, e := range st.rangeEntries(from, maxStreamID, count, false) {
+		eid, _ := parseStreamID(e.ID, 0)
+		g.lastID = eid
+		if !noAck {
+			p := g.assign(eid, c)
+			p.deliveryTime = now
+			p.deliveryCount = 1
+		}
+		res = append(res, e)
+	}
+	return res, nil
+}
+
+func (s *Datastore) XAck(key, group string, ids []string) (int, error) {
+	parsed := make([]streamID, len(ids))
+	for i, id := range ids {
+		p, err := parseStreamID(id, 0)
+		if err != nil {
+			return 0, err
+		}
+		parsed[i] = p
+	}
+
+	st, err := s.getStream(key)
+	if err != nil {
+		return 0, err
+	}
+	if st == nil {
+		return 0, nil
+	}
+	g, ok := st.groups[group]
+	if !ok {
+		return 0, nil
+	}
+
+	acked := 0
+	for _, id := range parsed {
+		if g.ack(id) {
+			acked++
+		}
+	}
+	return acked, nil
+}
+
+// XPendingSummary returns the number of pending entries, the smallest and
+// greatest pending IDs and the number of pending entries per consumer.
+func (s *Datastore) XPendingSummary(key, group string) (int, string, string, [][]string, error) {
+	_, g, err := s.getStreamGroup(key, group)
+	if err != nil {
+		return 0, "", "", nil, err
+	}
+	if len(g.pel) == 0 {
+		return 0, "", "", nil, nil
+	}
+
+	ids := g.pelIndex.rangeByRank(0, 0)
+	minID := ids[0]
+	ids = g.pelIndex.rangeByRankDesc(0, 0)
+	maxID := ids[0]
+
+	var consumers [][]string
+	for name, c := range g.consumers {
+		if c.pending.size > 0 {
+			consumers = append(consumers, []string{name, strconv.Itoa(c.pending.size)})
+		}
+	}
+	return len(g.pel), minID.String(), maxID.String(), consumers, nil
+}
+
+// XPendingRange lists pending entries between start and end, optionally only
+// those idle for at least minIdle ms and owned by consumer.
+func (s *Datastore) XPendingRange(key, group string, minIdle int64, start, end string, count int, consumer string) ([]StreamPendingEntry, error) {
+	from, okFrom, err := parseRangeID(start, false)
+	if err != nil {
+		return nil, err
+	}
+	to, okTo, err := parseRangeID(end, true)
+	if err != nil {
+		return nil, err
+	}
+
+	_, g, err := s.getStreamGroup(key, group)
+	if err != nil {
+		return nil, err
+	}
+
+	res := []StreamPendingEntry{}
+	if !okFrom || !okTo || count <= 0 {
+		return res, nil
+	}
+
+	now := time.Now()
+	g.pelIndex.ascend(from, func(id streamID) bool {
+		if to.less(id) {
+			return false
+		}
+		p := g.pel[id]
+		idle := now.Sub(p.deliveryTime).Milliseconds()
+		if idle < minIdle || (consumer != "" && p.consumer.name != consumer) {
+			return true
+		}
+		res = append(res, StreamPendingEntry{
+			ID:            id.String(),
+			Consumer:      p.consumer.name,
+			Idle:          idle,
+			DeliveryCount: p.deliveryCount,
+		})
+		return len(res) < count
+	})
+	return res, nil
+}
+
+// XClaim transfers ownership of pending entries idle for at least minIdle ms
+// to consumer and returns the claimed entries.
+func (s *Datastore) XClaim(key, group, consumer string, minIdle int64, ids []string, opts StreamClaimOptions) ([]StreamEntry, error) {
+	parsed := make([]streamID, len(ids))
+	for i, id := range ids {
+		p, err := parseStreamID(id, 0)
+		if err != nil {
+			return nil, err
+		}
+		parsed[i] = p
+	}
+	var lastID streamID
+	if opts.LastID != "" {
+		var err error
+		if lastID, err = parseStreamID(opts.LastID, 0); err != nil {
+			return nil, err
+		}
+	}
+
+	st, g, err := s.getStreamGroup(key, group)
+	if err != nil {
+		return nil, err
+	}
+	if g.lastID.less(lastID) {
+		g.lastID = lastID
+	}
+
+	now := time.Now()
+	deliveryTime := now
+	if opts.Idle >= 0 {
+		deliveryTime = now.Add(-time.Duration(opts.Idle) * time.Millisecond)
+	} else if opts.Time >= 0 {
+		deliveryTime = time.UnixMilli(opts.Time)
+	}
+
+	c, _ := g.consumer(consumer)
+	c.seenTime = now
+
+	res := []StreamEntry{}
+	for _, id := range parsed {
+		_, exists := st.entries[id]
+		p, pending := g.pel[id]
+		if !pending {
+			if !opts.Force || !exists {
+				continue
+			}
+		} else {
+			if !exists {
+				// the entry is gone, so nobody can ever process it
+				g.ack(id)
+				continue
+			}
+			if minIdle > 0 && now.Sub(p.deliveryTime).Milliseconds() < minIdle {
+				continue
+			}
+		}
+
+		p = g.assign(id, c)
+		p.deliveryTime = deliveryTime
+		if opts.RetryCount >= 0 {
+			p.deliveryCount = opts.RetryCount
+		} else if !opts.JustID {
+			p.deliveryCount++
+		}
+		res = append(res, st.entry(id))
+	}
+	return res, nil
+}
+
+// XAutoClaimAttemptsFactor is how many pending entries XAutoClaim scans per
+// entry it may claim. COUNT is bounded so that the product cannot overflow.
+const XAutoClaimAttemptsFactor = 10
+
+// XAutoClaim scans the pending list from start and claims up to count entries
+// idle for at least minIdle ms. It returns the cursor to continue from ("0-0"
+// once the scan is complete), the claimed entries and the IDs of pending
+// entries that no longer exist in the stream, which are dropped.
+func (s *Datastore) XAutoClaim(key, group, consumer string, minIdle int64, start string, count int, justID bool) (string, []StreamEntry, []string, error) {
+	from, ok, err := parseRangeID(start, false)
+	if err != nil {
+		return "", nil, nil, err
+	}
+
+	st, g, err := s.getStreamGroup(key, group)
+	if err != nil {
+		return "", nil, nil, err
+	}
+
+	claimed := []StreamEntry{}
+	deleted := []string{}
+	next := streamID{}
+	if !ok {
+		return next.String(), claimed, deleted, nil
+	}
+
+	c, _ := g.consumer(consumer)
+	now := time.Now()
+	c.seenTime = now
+
+	// collect one candidate past the scan budget: it becomes the next cursor
+	var candidates []streamID
+	attempts := count * XAutoClaimAttemptsFactor
+	g.pelIndex.ascend(from, func(id streamID) bool {
+		candidates = append(candidates, id)
+		return len(candidates) <= attempts
+	})
+
+	for i, id := range candidates {
+		if i == attempts || len(claimed) == count {
+			next = id
+			break
+		}
+		if _, exists := st.entries[id]; !exists {
+			g.ack(id)
+			deleted = append(deleted, id.String())
+			continue
+		}
+		p := g.pel[id]
+		if minIdle > 0 && now.Sub(p.deliveryTime).Milliseconds() < minIdle {
+			continue
+		}
+		p = g.assign(id, c)
+		p.deliveryTime = now
+		if !justID {
+			p.deliveryCount++
+		}
+		claimed = append(claimed, st.entry(id))
+	}
+	return next.String(), claimed, deleted, nil
+}
@@ -0,0 +1,423 @@
+package datastore
+
+import (
+	"backend/internal/config"
+	"math"
+	"sort"
+)
+
+// A t-digest summarizes a distribution with centroids, each a mean and the
+// number of values it absorbed. Centroids near the tails are kept small and
+// those near the median allowed to grow, as bounded by the k1 scale function
+// and the compression, so extreme quantiles stay accurate. Added values are
+// buffered and merged into the centroids in one sorted pass once the buffer
+// fills up or before a query.
+
+const TDigestDefaultCompression = 100
+
+type tdCentroid struct {
+	mean, weight float64
+}
+
+type EntryTDigest struct {
+	compression float64
+	centroids   []tdCentroid
+	// unmerged holds the values added since the last merge
+	unmerged []float64
+	// weight is the total weight of the centroids, without the unmerged values
+	weight   float64
+	min, max float64
+}
+
+func CreateEntryTDigest(compression float64) *EntryTDigest {
+	return &EntryTDigest{
+		compression: compression,
+		min:         math.Inf(1),
+		max:         math.Inf(-1),
+	}
+}
+
+func (td *EntryTDigest) count() float64 {
+	return td.weight + float64(len(td.unmerged))
+}
+
+func (td *EntryTDigest) add(v float64) {
+	td.unmerged = append(td.unmerged, v)
+	td.min, td.max = math.Min(td.min, v), math.Max(td.max, v)
+	if len(td.unmerged) >= int(5*td.compression) {
+		td.compress()
+	}
+}
+
+// kInverse is the inverse of the k1 scale function
+// k(q) = compression / 2pi * asin(2q - 1).
+func (td *EntryTDigest) kInverse(k float64) float64 {
+	return (math.Sin(2*math.Pi*k/td.compression) + 1) / 2
+}
+
+func (td *EntryTDigest) k(q float64) float64 {
+	return td.compression / (2 * math.Pi) * math.Asin(2*q-1)
+}
+
+// merge folds cs, sorted by mean, into as few centroids as the scale
+// function allows.
+func (td *EntryTDigest) merge(cs []tdCentroid) {
+	var total float64
+	for _, c := range cs {
+		total += c.weight
+	}
+
+	merged := make([]tdCentroid, 0, len(cs))
+	var before float64
+	limit := total * td.kInverse(td.k(0)+1)
+	for _, c := range cs {
+		last := len(merged) - 1
+		if last >= 0 && before+merged[last].weight+c.weight <= limit {
+			cur := &merged[last]
+			cur.weight += c.weight
+			cur.mean += (c.mean - cur.mean) * c.weight / cur.weight
+			continue
+		}
+		if last >= 0 {
+			before += merged[last].weight
+			limit = total * td.kInverse(td.k(before/total)+1)
+		}
+		merged = append(merged, c)
+	}
+	td.centroids, td.weight = merged, total
+}
+
+func (td *EntryTDigest) compress() {
+	if len(td.unmerged) == 0 {
+		return
+	}
+	cs := make([]tdCentroid, 0, len(td.centroids)+len(td.unmerged))
+	cs = append(cs, td.centroids...)
+	for _, v := range td.unmerged {
+		cs = append(cs, tdCentroid{mean: v, weight: 1})
+	}
+	td.unmerged = td.unmerged[:0]
+	sort.Slice(cs, func(i, j int) bool { return cs[i].mean < cs[j].mean })
+	td.merge(cs)
+}
+
+// points returns the cumulative weight at the center of every centroid
+// along with its mean, bracketed by (0, min) and (count, max).
+func (td *EntryTDigest) points() (pos, val []float64) {
+	pos = append(pos, 0)
+	val = append(val, td.min)
+	var cum float64
+	for _, c := range td.centroids {
+		pos = append(pos, cum+c.weight/2)
+		val = append(val, c.mean)
+		cum += c.weight
+	}
+	pos = append(pos, cum)
+	val = append(val, td.max)
+	return pos, val
+}
+
+// quantile interpolates the value below which a fraction q of the values lie.
+func (td *EntryTDigest) quantile(q float64) float64 {
+	td.compress()
+	if td.weight == 0 {
+		return math.NaN()
+	}
+	pos, val := td.points()
+	index := q * td.weight
+	i := sort.SearchFloat64s(pos, index)
+	if i == 0 {
+		return val[0]
+	}
+	if i == len(pos) {
+		return val[len(val)-1]
+	}
+	if pos[i] == pos[i-1] {
+		return val[i]
+	}
+	return val[i-1] + (index-pos[i-1])/(pos[i]-pos[i-1])*(val[i]-val[i-1])
+}
+
+// position interpolates the cumulative weight at value x; values equal to
+// x count half.
+func (td *EntryTDigest) position(x float64) float64 {
+	pos, val := td.points()
+	lo := sort.SearchFloat64s(val, x)
+	hi := sort.Search(len(val), func(i int) bool { return val[i] > x })
+	if lo < hi {
+		return (pos[lo] + pos[hi-1]) / 2
+	}
+	if lo == 0 {
+		return 0
+	}
+	if lo == len(val) {
+		return td.weight
+	}
+	return pos[lo-1] + (x-val[lo-1])/(val[lo]-val[lo-1])*(pos[lo]-pos[lo-1])
+}
+
+func (td *EntryTDigest) cdf(x float64) float64 {
+	td.compress()
+	if td.weight == 0 {
+		return math.NaN()
+	}
+	return td.position(x) / td.weight
+}
+
+// rank returns the estimated number of values below x, -1 when x is below
+// them all and the count when above.
+func (td *EntryTDigest) rank(x float64) int {
+	td.compress()
+	n := int(td.weight)
+	switch {
+	case n == 0:
+		return -2
+	case x < td.min:
+		return -1
+	case x > td.max:
+		return n
+	}
+	return min(max(int(math.Round(td.position(x)-0.5)), 0), n-1)
+}
+
+// revRank is rank counting from the largest value down.
+func (td *EntryTDigest) revRank(x float64) int {
+	td.compress()
+	n := int(td.weight)
+	switch {
+	case n == 0:
+		return -2
+	case x > td.max:
+		return -1
+	case x < td.min:
+		return n
+	}
+	return min(max(int(math.Round(td.weight-td.position(x)-0.5)), 0), n-1)
+}
+
+// byRank returns the estimated value of the given rank.
+func (td *EntryTDigest) byRank(rank int) float64 {
+	td.compress()
+	n := int(td.weight)
+	switch {
+	case n == 0:
+		return math.NaN()
+	case rank >= n:
+		return math.Inf(1)
+	case rank == 0:
+		return td.min
+	case rank == n-1:
+		return td.max
+	}
+	return td.quantile((float64(rank) + 0.5) / td.weight)
+}
+
+// trimmedMean averages the values between the lo and hi quantiles.
+func (td *EntryTDigest) trimmedMean(lo, hi float64) float64 {
+	td.compress()
+	if td.weight == 0 {
+		return math.NaN()
+	}
+	from, to := lo*td.weight, hi*td.weight
+	var sum, weight, cum float64
+	for _, c := range td.centroids {
+		overlap := math.Min(cum+c.weight, to) - math.Max(cum, from)
+		if overlap > 0 {
+			sum += c.mean * overlap
+			weight += overlap
+		}
+		cum += c.weight
+	}
+	if weight == 0 {
+		return math.NaN()
+	}
+	return sum / weight
+}
+
+func (td *EntryTDigest) clone() *EntryTDigest {
+	c := *td
+	c.centroids = append([]tdCentroid(nil), td.centroids...)
+	c.unmerged = append([]float64(nil), td.unmerged...)
+	return &c
+}
+
+func (s *Datastore) getTDigest(key string) (*EntryTDigest, error) {
+	e, ok := s.getEntry(key)
+	if !ok {
+		return nil, config.ErrKeyNotExist
+	}
+	td, ok := e.val.(*EntryTDigest)
+	if !ok {
+		return nil, config.ErrWrongType
+	}
+	return td, nil
+}
+
+// TDIGEST.CREATE
+func (s *Datastore) CreateTDigest(key string, compression float64) (bool, error) {
+	if e, ok := s.getEntry(key); ok {
+		if _, ok := e.val.(*EntryTDigest); ok {
+			return false, nil
+		}
+		return false, config.ErrWrongType
+	}
+	s.m[key] = Entry{val: CreateEntryTDigest(compression)}
+	return true, nil
+}
+
+// TDIGEST.ADD
+func (s *Datastore) TDigestAdd(key string, values []float64) error {
+	td, err := s.getTDigest(key)
+	if err != nil {
+		return err
+	}
+	for _, v := range values {
+		td.add(v)
+	}
+	return nil
+}
+
+// TDIGEST.RESET
+func (s *Datastore) TDigestReset(key string) error {
+	td, err := s.getTDigest(key)
+	if err != nil {
+		return err
+	}
+	*td = *CreateEntryTDigest(td.compression)
+	return nil
+}
+
+// tdigestQuery applies fn to each argument against the digest at key.
+func tdigestQuery[T, R any](s *Datastore, key string, args []T, fn func(td *EntryTDigest, arg T) R) ([]R, error) {
+	td, err := s.getTDigest(key)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]R, len(args))
+	for i, arg := range args {
+		res[i] = fn(td, arg)
+	}
+	return res, nil
+}
+
+// TDIGEST.QUANTILE
+func (s *Datastore) TDigestQuantile(key string, qs []float64) ([]float64, error) {
+	return tdigestQuery(s, key, qs, (*EntryTDigest).quantile)
+}
+
+// TDIGEST.CDF
+func (s *Datastore) TDigestCDF(key string, values []float64) ([]float64, error) {
+	return tdigestQuery(s, key, values, (*EntryTDigest).cdf)
+}
+
+// TDIGEST.RANK
+func (s *Datastore) TDigestRank(key string, values []float64) ([]int, error) {
+	return tdigestQuery(s, key, values, (*EntryTDigest).rank)
+}
+
+// TDIGEST.REVRANK
+func (s *Datastore) TDigestRevRank(key string, values []float64) ([]int, error) {
+	return tdigestQuery(s, key, values, (*EntryTDigest).revRank)
+}
+
+// TDIGEST.BYRANK
+func (s *Datastore) TDigestByRank(key string, ranks []int) ([]float64, error) {
+	return tdigestQuery(s, key, ranks, (*EntryTDigest).byRank)
+}
+
+// TDIGEST.BYREVRANK
+func (s *Datastore) TDigestByRevRank(key string, ranks []int) ([]float64, error) {
+	return tdigestQuery(s, key, ranks, func(td *EntryTDigest, rank int) float64 {
+		td.compress()
+		if n := int(td.weight); n > 0 && rank >= n {
+			return math.Inf(-1)
+		}
+		return td.byRank(int(td.weight) - 1 - rank)
+	})
+}
+
+// TDIGEST.TRIMMED_MEAN
+func (s *Datastore) TDigestTrimmedMean(key string, lo, hi float64) (float64, error) {
+	td, err := s.getTDigest(key)
+	if err != nil {
+		return 0, err
+	}
+	return td.trimmedMean(lo, hi), nil
+}
+
+// TDIGEST.MIN and TDIGEST.MAX
+// Both are NaN for an empty digest.
+func (s *Datastore) TDigestMinMax(key string) (float64, float64, error) {
+	td, err := s.getTDigest(key)
+	if err != nil {
+		return 0, 0, err
+	}
+	if td.count() == 0 {
+		return math.NaN(), math.NaN(), nil
+	}
+	return td.min, td.max, nil
+}
+
+type TDigestInfo struct {
+	Compression                float64
+	Merged, Unmerged           int
+	MergedWeight, Observations float64
+}
+
+// TDIGEST.INFO
+func (s *Datastore) TDigestInfo(key string) (TDigestInfo, error) {
+	td, err := s.getTDigest(key)
+	if err != nil {
+		return TDigestInfo{}, err
+	}
+	return TDigestInfo{
+		Compression:  td.compression,
+		Merged:       len(td.centroids),
+		Unmerged:     len(td.unmerged),
+		MergedWeight: td.weight,
+		Observations: td.count(),
+	}, nil
+}
+
+// CloneTDigest returns a copy of the digest at key, safe to read from another
+// goroutine, or nil if the key is missing.
+func (s *Datastore) CloneTDigest(key string) (*EntryTDigest, error) {
+	td, err := s.getTDigest(key)
+	if err == config.ErrKeyNotExist {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return td.clone(), nil
+}
+
+// Compression returns the compression td was created with.
+func (td *EntryTDigest) Compression() float64 {
+	return td.compression
+}
+
+// MergeTDigests combines digests into a new one with the given compression.
+func MergeTDigests(compression float64, tds []*EntryTDigest) *EntryTDigest {
+	res := CreateEntryTDigest(compression)
+	var cs []tdCentroid
+	for _, td := range tds {
+		td.compress()
+		cs = append(cs, td.centroids...)
+		res.min, res.max = math.Min(res.min, td.min), math.Max(res.max, td.max)
+	}
+	sort.Slice(cs, func(i, j int) bool { return cs[i].mean < cs[j].mean })
+	res.merge(cs)
+	return res
+}
+
+// TDigestStore overwrites key with td.
+func (s *Datastore) TDigestStore(key string, td *EntryTDigest) error {
+	if e, ok := s.getEntry(key); ok {
+		if _, ok := e.val.(*EntryTDigest); !ok {
+			return config.ErrWrongType
+		}
+	}
+	s.m[key] = Entry{val: td}
+	return nil
+}
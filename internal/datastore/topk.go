@@ -0,0 +1,227 @@
+package datastore
+
+import (
+	"backend/internal/config"
+	"container/heap"
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// A Top-K sketch follows HeavyKeeper: a depth x width table of buckets, each
+// holding an item fingerprint and a count, plus a min-heap of the k items
+// with the largest counts seen. An item owns a bucket in each row; an item
+// hashing to a bucket owned by another decays that owner's count with
+// probability decay^count, taking the bucket over once it reaches zero. Heavy
+// hitters keep their buckets while the long tail keeps evicting itself.
+
+const (
+	// topkFingerprintSeed keeps fingerprints independent of the row hashes.
+	topkFingerprintSeed = 1919
+	// topkDecayLookup caps the precomputed decay powers; past it decay^count
+	// is negligible.
+	topkDecayLookup  = 256
+	TopKMaxIncrement = 100000
+
+	// TopKMaxK caps the heap, which every update scans for the item.
+	TopKMaxK = 100000
+	// topkMaxBuckets caps width x depth, 512MB of buckets.
+	topkMaxBuckets = 1 << 26
+)
+
+type topkBucket struct {
+	fp    uint32
+	count uint32
+}
+
+type TopKItem struct {
+	Item  string
+	Count uint32
+	fp    uint32
+}
+
+// topkHeap is a min-heap on count.
+type topkHeap []TopKItem
+
+func (h topkHeap) Len() int           { return len(h) }
+func (h topkHeap) Less(i, j int) bool { return h[i].Count < h[j].Count }
+func (h topkHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *topkHeap) Push(x any)        { *h = append(*h, x.(TopKItem)) }
+func (h *topkHeap) Pop() any {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+type EntryTopK struct {
+	k, width, depth uint32
+	decay           float64
+	buckets         [][]topkBucket
+	// heap holds exactly k items, the empty ones with count 0
+	heap   topkHeap
+	decays [topkDecayLookup]float64
+}
+
+func CreateEntryTopK(k, width, depth uint32, decay float64) (*EntryTopK, error) {
+	if k > TopKMaxK || uint64(width)*uint64(depth) > topkMaxBuckets {
+		return nil, config.ErrTopKTooLarge
+	}
+	t := &EntryTopK{k: k, width: width, depth: depth, decay: decay}
+	t.buckets = make([][]topkBucket, depth)
+	for i := range t.buckets {
+		t.buckets[i] = make([]topkBucket, width)
+	}
+	t.heap = make(topkHeap, k)
+	for i := range t.decays {
+		t.decays[i] = math.Pow(decay, float64(i))
+	}
+	return t, nil
+}
+
+// find returns the heap index of item, or -1.
+func (t *EntryTopK) find(item string, fp uint32) int {
+	for i, h := range t.heap {
+		if h.fp == fp && h.Count > 0 && h.Item == item {
+			return i
+		}
+	}
+	return -1
+}
+
+// incrBy counts item incr more times and returns the item it pushed out of
+// the top k, if any.
+func (t *EntryTopK) incrBy(item string, incr uint32) (string, bool) {
+	fp := calcHash(item, topkFingerprintSeed)
+	var maxCount uint32
+	for i := uint32(0); i < t.depth; i++ {
+		b := &t.buckets[i][calcHash(item, i)%t.width]
+		switch {
+		case b.count == 0:
+			b.fp, b.count = fp, incr
+		case b.fp == fp:
+			b.count += min(incr, math.MaxUint32-b.count)
+		default:
+			for left := incr; left > 0; left-- {
+				if rand.Float64() < t.decays[min(b.count, topkDecayLookup-1)] {
+					b.count--
+					if b.count == 0 {
+						b.fp, b.count = fp, left
+						break
+					}
+				}
+			}
+		}
+		if b.fp == fp {
+			maxCount = max(maxCount, b.count)
+		}
+	}
+
+	if maxCount == 0 || maxCount < t.heap[0].Count {
+		return "", false
+	}
+	if i := t.find(item, fp); i >= 0 {
+		t.heap[i].Count = maxCount
+		heap.Fix(&t.heap, i)
+		return "", false
+	}
+	expelled := t.heap[0]
+	t.heap[0] = TopKItem{Item: item, Count: maxCount, fp: fp}
+	heap.Fix(&t.heap, 0)
+	return expelled.Item, expelled.Count > 0
+}
+
+func (s *Datastore) getTopK(key string) (*EntryTopK, error) {
+	e, ok := s.getEntry(key)
+	if !ok {
+		return nil, config.ErrKeyNotExist
+	}
+	t, ok := e.val.(*EntryTopK)
+	if !ok {
+		return nil, config.ErrWrongType
+	}
+	return t, nil
+}
+
+// TOPK.RESERVE
+func (s *Datastore) CreateTopK(key string, k, width, depth uint32, decay float64) (bool, error) {
+	if e, ok := s.m[key]; ok {
+		if _, ok := e.val.(*EntryTopK); ok {
+			return false, nil
+		}
+		return false, config.ErrWrongType
+	}
+	t, err := CreateEntryTopK(k, width, depth, decay)
+	if err != nil {
+		return false, err
+	}
+	s.m[key] = Entry{val: t}
+	return true, nil
+}
+
+// TOPK.INCRBY
+// expelled[i] is the item pushed out of the top k by items[i], when found[i].
+func (s *Datastore) TopKIncrBy(key string, items []string, incrs []uint32) ([]string, []bool, error) {
+	t, err := s.getTopK(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	expelled := make([]string, len(items))
+	found := make([]bool, len(items))
+	for i, item := range items {
+		expelled[i], found[i] = t.incrBy(item, incrs[i])
+	}
+	return expelled, found, nil
+}
+
+// TOPK.QUERY
+func (s *Datastore) TopKQuery(key string, items []string) ([]bool, error) {
+	t, err := s.getTopK(key)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]bool, len(items))
+	for i, item := range items {
+		res[i] = t.find(item, calcHash(item, topkFingerprintSeed)) >= 0
+	}
+	return res, nil
+}
+
+// TOPK.LIST
+// The items are ordered by count, largest first.
+func (s *Datastore) TopKList(key string) ([]TopKItem, error) {
+	t, err := s.getTopK(key)
+	if err != nil {
+		return nil, err
+	}
+
+	var res []TopKItem
+	for _, h := range t.heap {
+		if h.Count > 0 {
+			res = append(res, h)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Count != res[j].Count {
+			return res[i].Count > res[j].Count
+		}
+		return res[i].Item < res[j].Item
+	})
+	return res, nil
+}
+
+type TopKInfo struct {
+	K, Width, Depth uint32
+	Decay           float64
+}
+
+// TOPK.INFO
+func (s *Datastore) TopKInfo(key string) (TopKInfo, error) {
+	t, err := s.getTopK(key)
+	if err != nil {
+		return TopKInfo{}, err
+	}
+	return TopKInfo{K: t.k, Width: t.width, Depth: t.depth, Decay: t.decay}, nil
+}
@@ -2,12 +2,20 @@ package datastore
 
 import (
 	"backend/internal/config"
+	"math"
+	"math/rand"
 	"strconv"
 )
 
 type EntryZSetBPTree struct {
+	// small sets only, see zset_encoding.go
+	listpack []byte
+	lpCount  int
+
 	dict map[string]float64 // member -> score
-	tree *bptree
+	tree *bptree[bptKey]
+	// scan is the ZSCAN snapshot of the dict, see scanIndex
+	scan *scanIndex
 }
 
 func (s *Datastore) getZSet(key string) (*EntryZSetBPTree, error) {
@@ -31,44 +39,128 @@ func (s *Datastore) ensureZSet(key string) (*EntryZSetBPTree, error) {
 		return nil, config.ErrWrongType
 	}
 
-	newZSet := &EntryZSetBPTree{
-		dict: make(map[string]float64),
-		tree: newBPTree(),
-	}
+	newZSet := newZSet()
 	s.m[key] = Entry{val: newZSet}
 	return newZSet, nil
 }
 
+// ZAddOptions are the ZADD flags: NX only adds new members, XX only updates
+// existing ones, GT and LT only update when the new score is greater or less,
+// and CH counts updated members alongside added ones.
+type ZAddOptions struct {
+	NX, XX, GT, LT, CH bool
+}
+
+type zaddResult int
+
+const (
+	zaddRejected zaddResult = iota // blocked by NX, XX, GT or LT
+	zaddUnchanged
+	zaddAdded
+	zaddUpdated
+)
+
+func (zset *EntryZSetBPTree) zadd(member string, score float64, opts ZAddOptions, limits zsetLimits) zaddResult {
+	old, ok := zset.score(member)
+	if !ok {
+		if opts.XX {
+			return zaddRejected
+		}
+		zset.insert(bptKey{score: score, member: member}, limits)
+		return zaddAdded
+	}
+
+	if opts.NX || (opts.GT && score <= old) || (opts.LT && score >= old) {
+		return zaddRejected
+	}
+	if score == old {
+		return zaddUnchanged
+	}
+	zset.delete(bptKey{score: old, member: member})
+	zset.insert(bptKey{score: score, member: member}, limits)
+	return zaddUpdated
+}
+
+// zsetForAdd returns the sorted set at key, created unless XX makes that
+// pointless, or nil if there is nothing to update.
+func (s *Datastore) zsetForAdd(key string, opts ZAddOptions) (*EntryZSetBPTree, error) {
+	if opts.XX {
+		return s.getZSet(key)
+	}
+	return s.ensureZSet(key)
+}
+
 // ZADD
-func (s *Datastore) ZADD(key string, value []string) (int, error) {
-	ent, err := s.ensureZSet(key)
-	if err != nil {
-		return 0, err
+func (s *Datastore) ZADD(key string, opts ZAddOptions, value []string) (int, error) {
+	if len(value)%2 != 0 {
+		return 0, config.ErrSyntaxError
 	}
 
-	count := 0
-	for i := 0; i < len(value); i += 2 {
-		member := value[i+1]
-		score, err := strconv.ParseFloat(value[i], 64)
-		if err != nil {
-			return count, config.ErrScoreIsNotFloat
+	// parse every score first so that a bad one leaves the set untouched
+	scores := make([]float64, len(value)/2)
+	for i := range scores {
+		score, err := strconv.ParseFloat(value[2*i], 64)
+		if err != nil || math.IsNaN(score) {
+			return 0, config.ErrScoreIsNotFloat
 		}
+		scores[i] = score
+	}
 
-		if oldScore, ok := ent.dict[member]; ok {
-			if oldScore == score {
-				continue
+	members := make([]string, len(scores))
+	for i := range members {
+		members[i] = value[2*i+1]
+	}
+	return s.zaddScores(key, opts, scores, members)
+}
+
+// zaddScores is ZADD with the scores already parsed.
+func (s *Datastore) zaddScores(key string, opts ZAddOptions, scores []float64, members []string) (int, error) {
+	ent, err := s.zsetForAdd(key, opts)
+	if err != nil || ent == nil {
+		return 0, err
+	}
+
+	count := 0
+	for i, score := range scores {
+		switch ent.zadd(members[i], score, opts, s.zsetLimits) {
+		case zaddAdded:
+			count++
+		case zaddUpdated:
+			if opts.CH {
+				count++
 			}
-			ent.tree.delete(bptKey{score: oldScore, member: member})
 		}
-
-		ent.dict[member] = score
-		ent.tree.insert(bptKey{score: score, member: member})
-		count++
+	}
+	if ent.len() == 0 {
+		delete(s.m, key)
 	}
 
 	return count, nil
 }
 
+// ZINCRBY, ZADD INCR
+// The returned bool is false when opts kept the member from being updated.
+func (s *Datastore) ZIncrBy(key string, opts ZAddOptions, incr float64, member string) (float64, bool, error) {
+	ent, err := s.zsetForAdd(key, opts)
+	if err != nil || ent == nil {
+		return 0, false, err
+	}
+
+	score := incr
+	if old, ok := ent.score(member); ok {
+		score += old
+	}
+	if math.IsNaN(score) {
+		return 0, false, config.ErrScoreNaN
+	}
+
+	res := ent.zadd(member, score, opts, s.zsetLimits)
+	if ent.len() == 0 {
+		delete(s.m, key)
+	}
+	return score, res != zaddRejected, nil
+}
+
 // ZSCORE
 func (s *Datastore) ZScore(key, member string) (float64, bool, error) {
 	zset, err := s.getZSet(key)
@@ -79,7 +171,7 @@ func (s *Datastore) ZScore(key, member string) (float64, bool, error) {
 		return 0, false, nil
 	}
 
-	score, found := zset.dict[member]
+	score, found := zset.score(member)
 	return score, found, nil
 }
 
@@ -93,7 +185,7 @@ func (s *Datastore) ZCard(key string) (int, error) {
 		return 0, nil
 	}
 
-	return len(zset.dict), nil
+	return zset.len(), nil
 }
 
 // ZRANK
@@ -106,19 +198,19 @@ func (s *Datastore) ZRank(key, member string) (int, bool, error) {
 		return 0, false, nil
 	}
 
-	score, exists := zset.dict[member]
+	score, exists := zset.score(member)
 	if !exists {
 		return -1, false, nil
 	}
-	r := zset.tree.rankOf(bptKey{score: score, member: member})
+	r := zset.rankOf(bptKey{score: score, member: member})
 	if r < 0 {
 		return -1, false, nil
 	}
 	return r, true, nil
 }
 
-// ZRANGE
-func (s *Datastore) ZRange(key string, start, stop int) ([]string, error) {
+// ZRevRANGE
+func (s *Datastore) ZRevRange(key string, start, stop int) ([]string, error) {
 	zset, err := s.getZSet(key)
 	if err != nil {
 		return nil, err
@@ -127,7 +219,7 @@ func (s *Datastore) ZRange(key string, start, stop int) ([]string, error) {
 		return []string{}, nil
 	}
 
-	keys := zset.tree.rangeByRank(start, stop)
+	keys := zset.rangeByRankDesc(start, stop)
 	if len(keys) == 0 {
 		return []string{}, nil
 	}
@@ -139,8 +231,8 @@ func (s *Datastore) ZRange(key string, start, stop int) ([]string, error) {
 	return res, nil
 }
 
-// ZRANGE WITHSCORES
-func (s *Datastore) ZRangeWithScore(key string, start, stop int) ([]string, error) {
+// ZRevRANGE WITHSCORES
+func (s *Datastore) ZRevRangeWithScore(key string, start, stop int) ([]string, error) {
 	zset, err := s.getZSet(key)
 	if err != nil {
 		return nil, err
@@ -149,7 +241,7 @@ func (s *Datastore) ZRangeWithScore(key string, start, stop int) ([]string, erro
 		return []string{}, nil
 	}
 
-	keys := zset.tree.rangeByRank(start, stop)
+	keys := zset.rangeByRankDesc(start, stop)
 	if len(keys) == 0 {
 		return []string{}, nil
 	}
@@ -162,30 +254,218 @@ func (s *Datastore) ZRangeWithScore(key string, start, stop int) ([]string, erro
 	return res, nil
 }
 
-// ZRevRANGE
-func (s *Datastore) ZRevRange(key string, start, stop int) ([]string, error) {
+// ZREM
+func (s *Datastore) ZRem(key string, members []string) (int, error) {
 	zset, err := s.getZSet(key)
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
 	if zset == nil {
-		return []string{}, nil
+		return 0, nil
+	}
+
+	countDeleted := 0
+	for _, member := range members {
+		score, exists := zset.score(member)
+		if !exists {
+			continue
+		}
+		deleted := zset.delete(bptKey{score: score, member: member})
+		if deleted {
+			countDeleted++
+		}
+		if zset.len() == 0 {
+			delete(s.m, key)
+		}
+	}
+
+	return countDeleted, nil
+}
+
+// ZScoreRange bounds a score query; a bound is excluded when its Ex flag is set.
+type ZScoreRange struct {
+	Min, Max     float64
+	MinEx, MaxEx bool
+}
+
+// scoreRanks returns the rank interval [lo, hi) of the members within r.
+func (zset *EntryZSetBPTree) scoreRanks(r ZScoreRange) (int, int) {
+	lo := zset.countBefore(func(k bptKey) bool {
+		return k.score > r.Min || (!r.MinEx && k.score == r.Min)
+	})
+	hi := zset.countBefore(func(k bptKey) bool {
+		return k.score > r.Max || (r.MaxEx && k.score == r.Max)
+	})
+	return lo, hi
+}
+
+// rankWindow returns the keys ranked in [lo, hi), walked from the high end
+// when rev is set, after skipping offset of them and keeping at most count
+// (all when count is negative).
+func (zset *EntryZSetBPTree) rankWindow(lo, hi int, rev bool, offset, count int) []bptKey {
+	n := hi - lo - offset
+	if offset < 0 || n <= 0 {
+		return nil
+	}
+	if count >= 0 && count < n {
+		n = count
+	}
+	if n == 0 {
+		return nil
+	}
+
+	if !rev {
+		return zset.rangeByRank(lo+offset, lo+offset+n-1)
+	}
+	keys := zset.rangeByRank(hi-offset-n, hi-offset-1)
+	for i, j := 0, len(keys)-1; i < j; i, j = i+1, j-1 {
+		keys[i], keys[j] = keys[j], keys[i]
+	}
+	return keys
+}
+
+func zsetReply(keys []bptKey, withScores bool) []string {
+	if !withScores {
+		res := make([]string, len(keys))
+		for i, k := range keys {
+			res[i] = k.member
+		}
+		return res
+	}
+
+	res := make([]string, 0, len(keys)*2)
+	for _, k := range keys {
+		res = append(res, k.member, strconv.FormatFloat(k.score, 'f', -1, 64))
+	}
+	return res
+}
+
+// ZCOUNT
+func (s *Datastore) ZCount(key string, r ZScoreRange) (int, error) {
+	zset, err := s.getZSet(key)
+	if err != nil {
+		return 0, err
+	}
+	if zset == nil {
+		return 0, nil
+	}
+
+	lo, hi := zset.scoreRanks(r)
+	return max(hi-lo, 0), nil
+}
+
+// ZLexBound is one side of a lexicographic query: "-" and "+" set Inf to -1
+// and 1, otherwise Member is the bound, excluded when Exclusive is set.
+type ZLexBound struct {
+	Member    string
+	Exclusive bool
+	Inf       int
+}
+
+// ZLexRange bounds a lexicographic query. Like Redis, it assumes every member
+// has the same score; with mixed scores the result is unspecified.
+type ZLexRange struct {
+	Min, Max ZLexBound
+}
+
+// lexRanks returns the rank interval [lo, hi) of the members within r.
+func (zset *EntryZSetBPTree) lexRanks(r ZLexRange) (int, int) {
+	lo := zset.countBefore(func(k bptKey) bool {
+		if r.Min.Inf != 0 {
+			return r.Min.Inf < 0
+		}
+		return k.member > r.Min.Member || (!r.Min.Exclusive && k.member == r.Min.Member)
+	})
+	hi := zset.countBefore(func(k bptKey) bool {
+		if r.Max.Inf != 0 {
+			return r.Max.Inf < 0
+		}
+		return k.member > r.Max.Member || (r.Max.Exclusive && k.member == r.Max.Member)
+	})
+	return lo, hi
+}
+
+// ZLEXCOUNT
+func (s *Datastore) ZLexCount(key string, r ZLexRange) (int, error) {
+	zset, err := s.getZSet(key)
+	if err != nil {
+		return 0, err
+	}
+	if zset == nil {
+		return 0, nil
+	}
+
+	lo, hi := zset.lexRanks(r)
+	return max(hi-lo, 0), nil
+}
+
+// ZREMRANGEBYLEX
+func (s *Datastore) ZRemRangeByLex(key string, r ZLexRange) (int, error) {
+	zset, err := s.getZSet(key)
+	if err != nil {
+		return 0, err
+	}
+	if zset == nil {
+		return 0, nil
 	}
 
-	keys := zset.tree.rangeByRankDesc(start, stop)
+	lo, hi := zset.lexRanks(r)
+	return s.zsetRemoveRange(key, zset, lo, hi), nil
+}
+
+type ZRangeBy int
+
+const (
+	ZByRank ZRangeBy = iota
+	ZByScore
+	ZByLex
+)
+
+// ZRangeQuery is a parsed ZRANGE: Start and Stop apply by rank, Score and Lex
+// by score and lex. Offset and Count page the score and lex forms, where a
+// negative Count means no limit.
+type ZRangeQuery struct {
+	By            ZRangeBy
+	Start, Stop   int
+	Score         ZScoreRange
+	Lex           ZLexRange
+	Rev           bool
+	Offset, Count int
+}
+
+func (zset *EntryZSetBPTree) query(q ZRangeQuery) []bptKey {
+	switch q.By {
+	case ZByScore:
+		lo, hi := zset.scoreRanks(q.Score)
+		return zset.rankWindow(lo, hi, q.Rev, q.Offset, q.Count)
+	case ZByLex:
+		lo, hi := zset.lexRanks(q.Lex)
+		return zset.rankWindow(lo, hi, q.Rev, q.Offset, q.Count)
+	}
+	if q.Rev {
+		return zset.rangeByRankDesc(q.Start, q.Stop)
+	}
+	return zset.rangeByRank(q.Start, q.Stop)
+}
+
+// zsetStore replaces key with a sorted set of keys, or deletes it when keys
+// is empty, and returns the new cardinality.
+func (s *Datastore) zsetStore(key string, keys []bptKey) int {
 	if len(keys) == 0 {
-		return []string{}, nil
+		delete(s.m, key)
+		return 0
 	}
 
-	res := make([]string, len(keys))
-	for i, k := range keys {
-		res[i] = k.member
+	zset := newZSet()
+	for _, k := range keys {
+		zset.insert(k, s.zsetLimits)
 	}
-	return res, nil
+	s.m[key] = Entry{val: zset}
+	return len(keys)
 }
 
-// ZRevRANGE WITHSCORES
-func (s *Datastore) ZRevRangeWithScore(key string, start, stop int) ([]string, error) {
+// ZRANGE
+func (s *Datastore) ZQuery(key string, q ZRangeQuery, withScores bool) ([]string, error) {
 	zset, err := s.getZSet(key)
 	if err != nil {
 		return nil, err
@@ -194,44 +474,240 @@ func (s *Datastore) ZRevRangeWithScore(key string, start, stop int) ([]string, e
 		return []string{}, nil
 	}
 
-	keys := zset.tree.rangeByRankDesc(start, stop)
-	if len(keys) == 0 {
-		return []string{}, nil
+	return zsetReply(zset.query(q), withScores), nil
+}
+
+// ZRANGESTORE
+// It returns the scores of the members of key within q, for the coordinator to
+// store them with ZStore.
+func (s *Datastore) ZRangeScores(key string, q ZRangeQuery) (map[string]float64, error) {
+	zset, err := s.getZSet(key)
+	if err != nil || zset == nil {
+		return map[string]float64{}, err
 	}
 
-	res := make([]string, 0, len(keys)*2)
+	keys := zset.query(q)
+	scores := make(map[string]float64, len(keys))
 	for _, k := range keys {
-		res = append(res, k.member)
-		res = append(res, strconv.FormatFloat(k.score, 'f', -1, 64))
+		scores[k.member] = k.score
 	}
-	return res, nil
+	return scores, nil
 }
 
-// ZREM
-func (s *Datastore) ZRem(key string, members []string) (int, error) {
+// zsetRemoveRange deletes the members of zset ranked in [lo, hi), dropping
+// key once the set is empty, and returns how many went.
+func (s *Datastore) zsetRemoveRange(key string, zset *EntryZSetBPTree, lo, hi int) int {
+	n := len(zset.removeRange(lo, hi))
+	if zset.len() == 0 {
+		delete(s.m, key)
+	}
+	return n
+}
+
+// ZPOPMIN, ZPOPMAX
+// It returns member, score pairs, lowest first unless max is set, or nil
+// when key does not exist.
+func (s *Datastore) ZPop(key string, count int, max bool) ([]string, error) {
 	zset, err := s.getZSet(key)
-	if err != nil {
+	if err != nil || zset == nil {
+		return nil, err
+	}
+
+	lo, hi := 0, min(count, zset.len())
+	if max {
+		lo, hi = zset.len()-hi, zset.len()
+	}
+	keys := zset.removeRange(lo, hi)
+	if max {
+		for i, j := 0, len(keys)-1; i < j; i, j = i+1, j-1 {
+			keys[i], keys[j] = keys[j], keys[i]
+		}
+	}
+	if zset.len() == 0 {
+		delete(s.m, key)
+	}
+
+	return zsetReply(keys, true), nil
+}
+
+// ZREMRANGEBYRANK
+func (s *Datastore) ZRemRangeByRank(key string, start, stop int) (int, error) {
+	zset, err := s.getZSet(key)
+	if err != nil || zset == nil {
 		return 0, err
 	}
-	if zset == nil {
+
+	n := zset.len()
+	if start < 0 {
+		start += n
+	}
+	if stop < 0 {
+		stop += n
+	}
+	start, stop = max(start, 0), min(stop, n-1)
+	if start > stop {
 		return 0, nil
 	}
+	return s.zsetRemoveRange(key, zset, start, stop+1), nil
+}
 
-	countDeleted := 0
-	for _, member := range members {
-		score, exists := zset.dict[member]
-		if !exists {
-			continue
-		}
-		deleted := zset.tree.delete(bptKey{score: score, member: member})
-		if deleted {
-			delete(zset.dict, member)
-			countDeleted++
-		}
-		if len(zset.dict) == 0 {
-			delete(s.m, key)
+// ZREMRANGEBYSCORE
+func (s *Datastore) ZRemRangeByScore(key string, r ZScoreRange) (int, error) {
+	zset, err := s.getZSet(key)
+	if err != nil || zset == nil {
+		return 0, err
+	}
+
+	lo, hi := zset.scoreRanks(r)
+	return s.zsetRemoveRange(key, zset, lo, hi), nil
+}
+
+// ZScores returns a copy of the member scores of the sorted set at key for
+// the multi-key commands. A plain set counts as a sorted set whose members all
+// score 1, and a missing key as an empty one.
+func (s *Datastore) ZScores(key string) (map[string]float64, error) {
+	e, ok := s.getEntry(key)
+	if !ok {
+		return map[string]float64{}, nil
+	}
+
+	switch v := e.val.(type) {
+	case *EntryZSetBPTree:
+		scores := make(map[string]float64, v.len())
+		v.each(func(member string, score float64) {
+			scores[member] = score
+		})
+		return scores, nil
+	case *EntrySimpleSet:
+		scores := make(map[string]float64, v.len())
+		v.each(func(member string) bool {
+			scores[member] = 1
+			return true
+		})
+		return scores, nil
+	}
+	return nil, config.ErrWrongType
+}
+
+// ZStore replaces key with a sorted set of scores, or deletes it when scores
+// is empty, and returns the new cardinality.
+func (s *Datastore) ZStore(key string, scores map[string]float64) int {
+	keys := make([]bptKey, 0, len(scores))
+	for member, score := range scores {
+		keys = append(keys, bptKey{score: score, member: member})
+	}
+	return s.zsetStore(key, keys)
+}
+
+// ZREVRANK
+func (s *Datastore) ZRevRank(key, member string) (int, bool, error) {
+	zset, err := s.getZSet(key)
+	if err != nil || zset == nil {
+		return -1, false, err
+	}
+
+	score, exists := zset.score(member)
+	if !exists {
+		return -1, false, nil
+	}
+	r := zset.rankOf(bptKey{score: score, member: member})
+	if r < 0 {
+		return -1, false, nil
+	}
+	return zset.len() - 1 - r, true, nil
+}
+
+// ZMSCORE
+// found[i] reports whether members[i] is in the set.
+func (s *Datastore) ZMScore(key string, members []string) ([]float64, []bool, error) {
+	zset, err := s.getZSet(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	scores := make([]float64, len(members))
+	found := make([]bool, len(members))
+	if zset == nil {
+		return scores, found, nil
+	}
+	for i, member := range members {
+		scores[i], found[i] = zset.score(member)
+	}
+	return scores, found, nil
+}
+
+// ZRANDMEMBER
+// A positive count picks distinct members, a negative one picks -count
+// members that may repeat. A nil slice means the key does not exist.
+func (s *Datastore) ZRandMember(key string, count int, withScores bool) ([]string, error) {
+	zset, err := s.getZSet(key)
+	if err != nil || zset == nil {
+		return nil, err
+	}
+
+	var keys []bptKey
+	if count < 0 {
+		// every pick is a rank lookup, so the set is never materialized
+		for i := 0; i < -count; i++ {
+			keys = append(keys, zset.at(rand.Intn(zset.len())))
 		}
+	} else if count >= zset.len() {
+		keys = zset.rangeByRank(0, -1)
+	} else {
+		// reservoir sampling: a single pass without materializing the set
+		keys = make([]bptKey, 0, count)
+		seen := 0
+		zset.each(func(member string, score float64) {
+			k := bptKey{score: score, member: member}
+			if seen < count {
+				keys = append(keys, k)
+			} else if j := rand.Intn(seen + 1); j < count {
+				keys[j] = k
+			}
+			seen++
+		})
 	}
+	return zsetReply(keys, withScores), nil
+}
 
-	return countDeleted, nil
+// ZSCAN
+// It returns member, score pairs, visited in the order scanIndex defines. A
+// listpack is small enough to be returned whole in one call.
+func (s *Datastore) ZScan(key string, cursor uint64, pattern string, count int) (uint64, []string, error) {
+	zset, err := s.getZSet(key)
+	if err != nil {
+		return 0, nil, err
+	}
+	if zset == nil {
+		return 0, []string{}, nil
+	}
+
+	res := []string{}
+	if zset.isListpack() {
+		zset.each(func(member string, score float64) {
+			if scanMatch(pattern, member) {
+				res = append(res, member, strconv.FormatFloat(score, 'f', -1, 64))
+			}
+		})
+		return 0, res, nil
+	}
+
+	if cursor == 0 || zset.scan == nil {
+		zset.scan = newScanIndex(func(fn func(string)) {
+			for member := range zset.dict {
+				fn(member)
+			}
+		})
+	}
+	next, members := zset.scan.scan(cursor, count)
+	if next == 0 {
+		zset.scan = nil
+	}
+
+	for _, member := range members {
+		if score, ok := zset.dict[member]; ok && scanMatch(pattern, member) {
+			res = append(res, member, strconv.FormatFloat(score, 'f', -1, 64))
+		}
+	}
+	return next, res, nil
 }
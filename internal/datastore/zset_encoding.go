@@ -0,0 +1,225 @@
+package datastore
+
+import (
+	"encoding/binary"
+	"math"
+	"sort"
+)
+
+// A sorted set starts as a listpack: its entries packed back to back in one
+// []byte in (score, member) order, each the member as a set listpack entry
+// followed by the 8 bytes of its score. Past the limits below it converts,
+// never back, to a dict for member lookups plus a B+ tree for ordered access.
+// Listpack operations decode the entries, which stays cheap while they are few.
+
+type zsetLimits struct {
+	maxListpackEntries int
+	maxListpackValue   int
+}
+
+func newZSet() *EntryZSetBPTree {
+	return &EntryZSetBPTree{}
+}
+
+func (zset *EntryZSetBPTree) isListpack() bool {
+	return zset.tree == nil
+}
+
+func zlpAppend(lp []byte, k bptKey) []byte {
+	lp = lpAppend(lp, k.member)
+	return binary.BigEndian.AppendUint64(lp, math.Float64bits(k.score))
+}
+
+// lpKeys decodes the listpack entries, in order.
+func (zset *EntryZSetBPTree) lpKeys() []bptKey {
+	keys := make([]bptKey, 0, zset.lpCount)
+	for off := 0; off < len(zset.listpack); {
+		var k bptKey
+		k.member, off = lpNext(zset.listpack, off)
+		k.score = math.Float64frombits(binary.BigEndian.Uint64(zset.listpack[off:]))
+		off += 8
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+func (zset *EntryZSetBPTree) lpStore(keys []bptKey) {
+	lp := zset.listpack[:0]
+	for _, k := range keys {
+		lp = zlpAppend(lp, k)
+	}
+	zset.listpack, zset.lpCount = lp, len(keys)
+}
+
+func (zset *EntryZSetBPTree) len() int {
+	if zset.isListpack() {
+		return zset.lpCount
+	}
+	return zset.tree.size
+}
+
+func (zset *EntryZSetBPTree) score(member string) (float64, bool) {
+	if !zset.isListpack() {
+		score, ok := zset.dict[member]
+		return score, ok
+	}
+	for _, k := range zset.lpKeys() {
+		if k.member == member {
+			return k.score, true
+		}
+	}
+	return 0, false
+}
+
+// each calls fn for every member, in order for a listpack and in dict order otherwise.
+func (zset *EntryZSetBPTree) each(fn func(member string, score float64)) {
+	if zset.isListpack() {
+		for _, k := range zset.lpKeys() {
+			fn(k.member, k.score)
+		}
+		return
+	}
+	for member, score := range zset.dict {
+		fn(member, score)
+	}
+}
+
+// insert adds k, whose member must not be in the set yet, converting to the
+// dict and tree first if k would not fit in the listpack.
+func (zset *EntryZSetBPTree) insert(k bptKey, limits zsetLimits) {
+	if zset.isListpack() {
+		if zset.lpCount < limits.maxListpackEntries && len(k.member) <= limits.maxListpackValue {
+			keys := zset.lpKeys()
+			i := sort.Search(len(keys), func(i int) bool { return !keys[i].less(k) })
+			keys = append(keys, bptKey{})
+			copy(keys[i+1:], keys[i:])
+			keys[i] = k
+			zset.lpStore(keys)
+			return
+		}
+		zset.convert()
+	}
+	zset.dict[k.member] = k.score
+	zset.tree.insert(k)
+}
+
+func (zset *EntryZSetBPTree) delete(k bptKey) bool {
+	if !zset.isListpack() {
+		if !zset.tree.delete(k) {
+			return false
+		}
+		delete(zset.dict, k.member)
+		return true
+	}
+	keys := zset.lpKeys()
+	for i := range keys {
+		if keys[i].equal(k) {
+			zset.lpStore(append(keys[:i], keys[i+1:]...))
+			return true
+		}
+	}
+	return false
+}
+
+// removeRange deletes the members ranked in [lo, hi) and returns them.
+func (zset *EntryZSetBPTree) removeRange(lo, hi int) []bptKey {
+	if !zset.isListpack() {
+		keys := zset.tree.deleteRange(lo, hi)
+		for _, k := range keys {
+			delete(zset.dict, k.member)
+		}
+		return keys
+	}
+
+	lo, hi = max(lo, 0), min(hi, zset.lpCount)
+	if lo >= hi {
+		return nil
+	}
+	keys := zset.lpKeys()
+	removed := append([]bptKey(nil), keys[lo:hi]...)
+	zset.lpStore(append(keys[:lo], keys[hi:]...))
+	return removed
+}
+
+func (zset *EntryZSetBPTree) rankOf(k bptKey) int {
+	if !zset.isListpack() {
+		return zset.tree.rankOf(k)
+	}
+	for i, lk := range zset.lpKeys() {
+		if lk.equal(k) {
+			return i
+		}
+	}
+	return -1
+}
+
+// at returns the key of the given rank, which must be in range.
+func (zset *EntryZSetBPTree) at(rank int) bptKey {
+	if zset.isListpack() {
+		return zset.lpKeys()[rank]
+	}
+	leaf, idx := zset.tree.leafAtRank(rank)
+	return leaf.keys[idx]
+}
+
+// countBefore is bptree.countBefore for either encoding.
+func (zset *EntryZSetBPTree) countBefore(atOrAfter func(bptKey) bool) int {
+	if !zset.isListpack() {
+		return zset.tree.countBefore(atOrAfter)
+	}
+	keys := zset.lpKeys()
+	return sort.Search(len(keys), func(i int) bool { return atOrAfter(keys[i]) })
+}
+
+// rangeByRank is bptree.rangeByRank for either encoding.
+func (zset *EntryZSetBPTree) rangeByRank(start, stop int) []bptKey {
+	if !zset.isListpack() {
+		return zset.tree.rangeByRank(start, stop)
+	}
+	start, stop, ok := normalizeRanks(start, stop, zset.lpCount)
+	if !ok {
+		return nil
+	}
+	return zset.lpKeys()[start : stop+1]
+}
+
+// rangeByRankDesc is bptree.rangeByRankDesc for either encoding.
+func (zset *EntryZSetBPTree) rangeByRankDesc(start, stop int) []bptKey {
+	if !zset.isListpack() {
+		return zset.tree.rangeByRankDesc(start, stop)
+	}
+	start, stop, ok := normalizeRanks(start, stop, zset.lpCount)
+	if !ok {
+		return nil
+	}
+	keys := zset.lpKeys()
+	res := make([]bptKey, 0, stop-start+1)
+	for i := start; i <= stop; i++ {
+		res = append(res, keys[len(keys)-1-i])
+	}
+	return res
+}
+
+// normalizeRanks resolves negative ranks against n and clamps them, reporting
+// false when the range is empty.
+func normalizeRanks(start, stop, n int) (int, int, bool) {
+	if start < 0 {
+		start += n
+	}
+	if stop < 0 {
+		stop += n
+	}
+	start, stop = max(start, 0), min(stop, n-1)
+	return start, stop, start <= stop
+}
+
+func (zset *EntryZSetBPTree) convert() {
+	keys := zset.lpKeys()
+	zset.listpack, zset.lpCount = nil, 0
+	zset.dict = make(map[string]float64, len(keys))
+	zset.tree = newBPTree[bptKey]()
+	for _, k := range keys {
+		zset.dict[k.member] = k.score
+		zset.tree.insert(k)
+	}
+}
@@ -0,0 +1,119 @@
+package poller
+
+import (
+	"backend/internal/payload"
+	"backend/internal/protocol/resp"
+	"backend/internal/worker"
+	"net"
+	"strings"
+)
+
+// blockedConn is a connection whose command is parked on a worker.
+// Commands the client sends meanwhile are queued and run once it is replied.
+type blockedConn struct {
+	task     *payload.Task
+	workerID int
+	pending  []*payload.Command
+}
+
+// cmdKeys maps commands whose keys are not simply their first argument to a
+// function returning those keys. All of them must live on a single worker.
+var cmdKeys = map[string]func(args []string) []string{
+	"BLPOP":      allButLast,
+	"BRPOP":      allButLast,
+	"BZPOPMIN":   allButLast,
+	"BZPOPMAX":   allButLast,
+	"LMOVE":      firstTwo,
+	"BLMOVE":     firstTwo,
+	"XREAD":      streamsKeys,
+	"XREADGROUP": streamsKeys,
+	"XGROUP":     second,
+	"OBJECT":     second,
+}
+
+func allButLast(args []string) []string {
+	if len(args) == 0 {
+		return nil
+	}
+	return args[:len(args)-1]
+}
+
+func firstTwo(args []string) []string {
+	if len(args) < 2 {
+		return args
+	}
+	return args[:2]
+}
+
+func second(args []string) []string {
+	if len(args) < 2 {
+		return nil
+	}
+	return args[1:2]
+}
+
+// streamsKeys returns the keys following the STREAMS token of XREAD and XREADGROUP.
+func streamsKeys(args []string) []string {
+	for i, arg := range args {
+		if strings.EqualFold(arg, "STREAMS") {
+			rest := args[i+1:]
+			return rest[:len(rest)/2]
+		}
+	}
+	return nil
+}
+
+// dispatchBlocking hands a blocking command to its worker and waits for the
+// reply on a separate goroutine, so the event loop keeps serving other clients.
+func (h *IOHandler) dispatchBlocking(fd int, conn net.Conn, cmd *payload.Command) {
+	b := &blockedConn{pending: []*payload.Command{cmd}}
+	h.mu.Lock()
+	h.blocked[fd] = b
+	h.mu.Unlock()
+
+	go h.serveBlocked(fd, conn, b)
+}
+
+func (h *IOHandler) serveBlocked(fd int, conn net.Conn, b *blockedConn) {
+	for {
+		h.mu.Lock()
+		if h.blocked[fd] != b || len(b.pending) == 0 {
+			// the connection was closed, or it has nothing left to run
+			if h.blocked[fd] == b {
+				delete(h.blocked, fd)
+			}
+			h.mu.Unlock()
+			return
+		}
+		cmd := b.pending[0]
+		b.pending = b.pending[1:]
+		h.mu.Unlock()
+
+		if !worker.BlockingCmds[cmd.Cmd] {
+			conn.Write(h.execute(cmd))
+			continue
+		}
+
+		workerID, err := h.route(cmd)
+		if err != nil {
+			conn.Write(resp.Encode(err, false))
+			continue
+		}
+
+		task := &payload.Task{
+			Command: cmd,
+			ReplyCh: make(chan []byte, 1),
+			Done:    make(chan struct{}),
+		}
+		h.mu.Lock()
+		if h.blocked[fd] != b {
+			h.mu.Unlock()
+			return
+		}
+		b.task, b.workerID = task, workerID
+		h.mu.Unlock()
+
+		h.Workers[workerID].TaskCh <- task
+		conn.Write(<-task.ReplyCh)
+	}
+}
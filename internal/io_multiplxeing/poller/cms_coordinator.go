@@ -0,0 +1,62 @@
+package poller
+
+import (
+	"backend/internal/config"
+	"backend/internal/datastore"
+	"backend/internal/protocol/resp"
+	"strconv"
+	"strings"
+)
+
+// cmdCMSMERGE handles CMS.MERGE destination numKeys source... [WEIGHTS weight...].
+// The destination must already exist; its counters are replaced by the
+// weighted sum of the sources'.
+func (h *IOHandler) cmdCMSMERGE(args []string) []byte {
+	if len(args) < 3 {
+		return resp.Encode(config.ErrWrongNumberArguments, false)
+	}
+
+	dst := args[0]
+	keys, err := parseNumKeys(args[1:])
+	if err != nil {
+		return resp.Encode(err, false)
+	}
+
+	weights := make([]uint32, len(keys))
+	rest := args[2+len(keys):]
+	if len(rest) > 0 {
+		if len(rest) != len(keys)+1 || !strings.EqualFold(rest[0], "WEIGHTS") {
+			return resp.Encode(config.ErrSyntaxError, false)
+		}
+		for i, arg := range rest[1:] {
+			w, err := strconv.ParseUint(arg, 10, 32)
+			if err != nil {
+				return resp.Encode(config.ErrValueNotIntegerOrOutOfRange, false)
+			}
+			weights[i] = uint32(w)
+		}
+	} else {
+		for i := range weights {
+			weights[i] = 1
+		}
+	}
+
+	srcs := make([]*datastore.EntryCMS, len(keys))
+	errs := make([]error, len(keys))
+	h.onKeys(keys, func(ds *datastore.Datastore, i int) {
+		srcs[i], errs[i] = ds.CloneCMS(keys[i])
+	})
+	for _, err := range errs {
+		if err != nil {
+			return resp.Encode(err, false)
+		}
+	}
+
+	h.onKey(dst, func(ds *datastore.Datastore) {
+		err = ds.Merge(dst, srcs, weights)
+	})
+	if err != nil {
+		return resp.Encode(err, false)
+	}
+	return config.RespOk
+}
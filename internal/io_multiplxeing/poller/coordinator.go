@@ -0,0 +1,321 @@
+package poller
+
+import (
+	"backend/internal/config"
+	"backend/internal/datastore"
+	"backend/internal/protocol/resp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// coordinatedCmds are commands whose keys may be owned by different workers.
+// The I/O handler runs them itself: it reads every source key on the worker
+// owning it, combines the results, then writes the destination key, if any,
+// on its own worker. They are not atomic across workers.
+var coordinatedCmds = map[string]func(h *IOHandler, args []string) []byte{
+	"SINTER":         (*IOHandler).cmdSINTER,
+	"SUNION":         (*IOHandler).cmdSUNION,
+	"SDIFF":          (*IOHandler).cmdSDIFF,
+	"SINTERSTORE":    (*IOHandler).cmdSINTERSTORE,
+	"SUNIONSTORE":    (*IOHandler).cmdSUNIONSTORE,
+	"SDIFFSTORE":     (*IOHandler).cmdSDIFFSTORE,
+	"SMOVE":          (*IOHandler).cmdSMOVE,
+	"SINTERCARD":     (*IOHandler).cmdSINTERCARD,
+	"ZUNION":         (*IOHandler).cmdZUNION,
+	"ZINTER":         (*IOHandler).cmdZINTER,
+	"ZDIFF":          (*IOHandler).cmdZDIFF,
+	"ZUNIONSTORE":    (*IOHandler).cmdZUNIONSTORE,
+	"ZINTERSTORE":    (*IOHandler).cmdZINTERSTORE,
+	"ZDIFFSTORE":     (*IOHandler).cmdZDIFFSTORE,
+	"ZRANGESTORE":    (*IOHandler).cmdZRANGESTORE,
+	"GEOSEARCHSTORE": (*IOHandler).cmdGEOSEARCHSTORE,
+	"CMS.MERGE":      (*IOHandler).cmdCMSMERGE,
+	"PFCOUNT":        (*IOHandler).cmdPFCOUNT,
+	"PFMERGE":        (*IOHandler).cmdPFMERGE,
+	"TDIGEST.MERGE":  (*IOHandler).cmdTDIGESTMERGE,
+}
+
+// onKeys calls fn for the index of every key, on the worker owning that key.
+// Each worker involved is visited once and all of them run concurrently.
+func (h *IOHandler) onKeys(keys []string, fn func(ds *datastore.Datastore, i int)) {
+	byWorker := make(map[int][]int)
+	for i, key := range keys {
+		id := h.getPartitionID(key)
+		byWorker[id] = append(byWorker[id], i)
+	}
+
+	var wg sync.WaitGroup
+	for id, idxs := range byWorker {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			h.Workers[id].Exec(func(ds *datastore.Datastore) {
+				for _, i := range idxs {
+					fn(ds, i)
+				}
+			})
+		}()
+	}
+	wg.Wait()
+}
+
+// onKey runs fn on the worker owning key.
+func (h *IOHandler) onKey(key string, fn func(ds *datastore.Datastore)) {
+	h.Workers[h.getPartitionID(key)].Exec(fn)
+}
+
+// onKeyWrite is onKey for a fn that writes key, waking clients blocked on it.
+func (h *IOHandler) onKeyWrite(key string, fn func(ds *datastore.Datastore)) {
+	h.Workers[h.getPartitionID(key)].ExecWrite(key, fn)
+}
+
+func (h *IOHandler) gatherSets(keys []string) ([][]string, error) {
+	sets := make([][]string, len(keys))
+	errs := make([]error, len(keys))
+	h.onKeys(keys, func(ds *datastore.Datastore, i int) {
+		sets[i], errs[i] = ds.SMembers(keys[i])
+	})
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return sets, nil
+}
+
+// intersectSets returns the members found in every set, stopping at limit
+// members when limit > 0.
+func intersectSets(sets [][]string, limit int) []string {
+	smallest := 0
+	for i, set := range sets {
+		if len(set) < len(sets[smallest]) {
+			smallest = i
+		}
+	}
+
+	others := make([]map[string]struct{}, 0, len(sets)-1)
+	for i, set := range sets {
+		if i == smallest {
+			continue
+		}
+		m := make(map[string]struct{}, len(set))
+		for _, member := range set {
+			m[member] = struct{}{}
+		}
+		others = append(others, m)
+	}
+
+	res := []string{}
+	for _, member := range sets[smallest] {
+		inAll := true
+		for _, m := range others {
+			if _, ok := m[member]; !ok {
+				inAll = false
+				break
+			}
+		}
+		if inAll {
+			res = append(res, member)
+			if limit > 0 && len(res) == limit {
+				break
+			}
+		}
+	}
+	return res
+}
+
+func unionSets(sets [][]string) []string {
+	seen := make(map[string]struct{})
+	res := []string{}
+	for _, set := range sets {
+		for _, member := range set {
+			if _, ok := seen[member]; !ok {
+				seen[member] = struct{}{}
+				res = append(res, member)
+			}
+		}
+	}
+	return res
+}
+
+// diffSets returns the members of the first set missing from all the others.
+func diffSets(sets [][]string) []string {
+	exclude := make(map[string]struct{})
+	for _, set := range sets[1:] {
+		for _, member := range set {
+			exclude[member] = struct{}{}
+		}
+	}
+	res := []string{}
+	for _, member := range sets[0] {
+		if _, ok := exclude[member]; !ok {
+			res = append(res, member)
+		}
+	}
+	return res
+}
+
+func (h *IOHandler) setAlgebra(keys []string, op func(sets [][]string) []string) ([]string, error) {
+	sets, err := h.gatherSets(keys)
+	if err != nil {
+		return nil, err
+	}
+	return op(sets), nil
+}
+
+func (h *IOHandler) setAlgebraCmd(args []string, op func(sets [][]string) []string) []byte {
+	if len(args) < 1 {
+		return resp.Encode(config.ErrWrongNumberArguments, false)
+	}
+
+	members, err := h.setAlgebra(args, op)
+	if err != nil {
+		return resp.Encode(err, false)
+	}
+	return resp.Encode(members, false)
+}
+
+func (h *IOHandler) setAlgebraStoreCmd(args []string, op func(sets [][]string) []string) []byte {
+	if len(args) < 2 {
+		return resp.Encode(config.ErrWrongNumberArguments, false)
+	}
+
+	dst := args[0]
+	members, err := h.setAlgebra(args[1:], op)
+	if err != nil {
+		return resp.Encode(err, false)
+	}
+
+	var n int
+	h.onKeyWrite(dst, func(ds *datastore.Datastore) {
+		n = ds.SStore(dst, members)
+	})
+	return resp.Encode(n, false)
+}
+
+func intersectAll(sets [][]string) []string {
+	return intersectSets(sets, 0)
+}
+
+func (h *IOHandler) cmdSINTER(args []string) []byte {
+	return h.setAlgebraCmd(args, intersectAll)
+}
+
+func (h *IOHandler) cmdSUNION(args []string) []byte {
+	return h.setAlgebraCmd(args, unionSets)
+}
+
+func (h *IOHandler) cmdSDIFF(args []string) []byte {
+	return h.setAlgebraCmd(args, diffSets)
+}
+
+func (h *IOHandler) cmdSINTERSTORE(args []string) []byte {
+	return h.setAlgebraStoreCmd(args, intersectAll)
+}
+
+func (h *IOHandler) cmdSUNIONSTORE(args []string) []byte {
+	return h.setAlgebraStoreCmd(args, unionSets)
+}
+
+func (h *IOHandler) cmdSDIFFSTORE(args []string) []byte {
+	return h.setAlgebraStoreCmd(args, diffSets)
+}
+
+// parseNumKeys parses the numkeys argument at args[0] and returns the keys following it.
+func parseNumKeys(args []string) ([]string, error) {
+	numKeys, err := strconv.Atoi(args[0])
+	if err != nil {
+		return nil, config.ErrValueNotIntegerOrOutOfRange
+	}
+	if numKeys <= 0 {
+		return nil, config.ErrNumKeysNotPositive
+	}
+	if numKeys > len(args)-1 {
+		return nil, config.ErrNumKeysTooMany
+	}
+	return args[1 : 1+numKeys], nil
+}
+
+func (h *IOHandler) cmdSINTERCARD(args []string) []byte {
+	if len(args) < 2 {
+		return resp.Encode(config.ErrWrongNumberArguments, false)
+	}
+
+	keys, err := parseNumKeys(args)
+	if err != nil {
+		return resp.Encode(err, false)
+	}
+
+	limit := 0
+	rest := args[1+len(keys):]
+	if len(rest) > 0 {
+		if len(rest) != 2 || !strings.EqualFold(rest[0], "LIMIT") {
+			return resp.Encode(config.ErrSyntaxError, false)
+		}
+		limit, err = strconv.Atoi(rest[1])
+		if err != nil {
+			return resp.Encode(config.ErrValueNotIntegerOrOutOfRange, false)
+		}
+		if limit < 0 {
+			return resp.Encode(config.ErrLimitNegative, false)
+		}
+	}
+
+	sets, err := h.gatherSets(keys)
+	if err != nil {
+		return resp.Encode(err, false)
+	}
+	return resp.Encode(len(intersectSets(sets, limit)), false)
+}
+
+// cmdSMOVE handles SMOVE source destination member. When the keys live on
+// different workers the member leaves the source before it joins the
+// destination, and goes back should the destination no longer take it.
+func (h *IOHandler) cmdSMOVE(args []string) []byte {
+	if len(args) != 3 {
+		return resp.Encode(config.ErrWrongNumberArguments, false)
+	}
+
+	src, dst, member := args[0], args[1], args[2]
+	var n int
+	var err error
+	if h.getPartitionID(src) == h.getPartitionID(dst) {
+		h.onKeyWrite(dst, func(ds *datastore.Datastore) {
+			n, err = ds.SMove(src, dst, member)
+		})
+		if err != nil {
+			return resp.Encode(err, false)
+		}
+		return resp.Encode(n, false)
+	}
+
+	// the destination must hold a set, if anything
+	h.onKey(dst, func(ds *datastore.Datastore) {
+		_, err = ds.SIsMember(dst, member)
+	})
+	if err != nil {
+		return resp.Encode(err, false)
+	}
+
+	h.onKey(src, func(ds *datastore.Datastore) {
+		n, err = ds.SRem(src, []string{member})
+	})
+	if err != nil {
+		return resp.Encode(err, false)
+	}
+	if n == 0 {
+		return resp.Encode(0, false)
+	}
+
+	h.onKeyWrite(dst, func(ds *datastore.Datastore) {
+		_, err = ds.SADD(dst, []string{member})
+	})
+	if err != nil {
+		h.onKeyWrite(src, func(ds *datastore.Datastore) {
+			ds.SADD(src, []string{member})
+		})
+		return resp.Encode(err, false)
+	}
+	return resp.Encode(1, false)
+}
@@ -0,0 +1,87 @@
+package poller
+
+import (
+	"backend/internal/config"
+	"backend/internal/datastore"
+	"backend/internal/protocol/resp"
+)
+
+// gatherHLLs returns the union of the HyperLogLogs at keys, missing keys
+// counting as empty.
+func (h *IOHandler) gatherHLLs(keys []string) ([]uint8, error) {
+	regs := make([][]uint8, len(keys))
+	errs := make([]error, len(keys))
+	h.onKeys(keys, func(ds *datastore.Datastore, i int) {
+		regs[i], errs[i] = ds.HLLRegisters(keys[i])
+	})
+
+	merged := datastore.HLLNewRegisters()
+	for i, r := range regs {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+		mergeHLLRegisters(merged, r)
+	}
+	return merged, nil
+}
+
+// mergeHLLRegisters raises the registers of dst to those of src.
+func mergeHLLRegisters(dst, src []uint8) {
+	for j, v := range src {
+		dst[j] = max(dst[j], v)
+	}
+}
+
+// cmdPFCOUNT estimates the cardinality of the union of the keys. A single key
+// is counted on its own worker, where the estimate is cached.
+func (h *IOHandler) cmdPFCOUNT(args []string) []byte {
+	if len(args) < 1 {
+		return resp.Encode(config.ErrWrongNumberArguments, false)
+	}
+
+	if len(args) == 1 {
+		var n uint64
+		var err error
+		h.onKey(args[0], func(ds *datastore.Datastore) {
+			n, err = ds.PFCount(args[0])
+		})
+		if err != nil {
+			return resp.Encode(err, false)
+		}
+		return resp.Encode(n, false)
+	}
+
+	merged, err := h.gatherHLLs(args)
+	if err != nil {
+		return resp.Encode(err, false)
+	}
+	return resp.Encode(datastore.HLLCardinality(merged), false)
+}
+
+// cmdPFMERGE handles PFMERGE destkey [sourcekey...], merging the destination
+// itself with the sources. The destination is read and written in one go on
+// its worker, so that no PFADD to it in between is lost.
+func (h *IOHandler) cmdPFMERGE(args []string) []byte {
+	if len(args) < 1 {
+		return resp.Encode(config.ErrWrongNumberArguments, false)
+	}
+
+	dst := args[0]
+	merged, err := h.gatherHLLs(args[1:])
+	if err != nil {
+		return resp.Encode(err, false)
+	}
+
+	h.onKey(dst, func(ds *datastore.Datastore) {
+		var regs []uint8
+		if regs, err = ds.HLLRegisters(dst); err != nil {
+			return
+		}
+		mergeHLLRegisters(merged, regs)
+		ds.HLLStore(dst, merged)
+	})
+	if err != nil {
+		return resp.Encode(err, false)
+	}
+	return config.RespOk
+}
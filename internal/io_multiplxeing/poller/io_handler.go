@@ -21,6 +21,7 @@ type IOHandler struct {
 	NumWorker int
 	mu        sync.Mutex
 	Conns     map[int]net.Conn
+	blocked   map[int]*blockedConn
 }
 
 func NewIOHandler(id int, workers []*worker.Worker, numWorker int) (*IOHandler, error) {
@@ -35,6 +36,7 @@ func NewIOHandler(id int, workers []*worker.Worker, numWorker int) (*IOHandler,
 		Workers:   workers,
 		NumWorker: numWorker,
 		Conns:     make(map[int]net.Conn), // map from fd to corresponding connection
+		blocked:   make(map[int]*blockedConn),
 	}, nil
 }
 
@@ -92,96 +94,120 @@ func (h *IOHandler) Start() {
 				continue
 			}
 
-			replyCh := make(chan []byte, 1)
-			task := &payload.Task{
-				Command: cmd,
-				ReplyCh: replyCh,
+			h.mu.Lock()
+			if b, ok := h.blocked[connFd]; ok {
+				// keep the reply order: run it after the parked command
+				b.pending = append(b.pending, cmd)
+				h.mu.Unlock()
+				continue
 			}
+			h.mu.Unlock()
 
-			// inside IOHandler.Start() loop, after building `task` and before dispatching normally:
-			if task.Command.Cmd == "KEYS" {
-				// Broadcast to all workers and aggregate
-				// create per-worker reply channels and send tasks
-				replyChs := make([]chan []byte, len(h.Workers))
-				for i, wk := range h.Workers {
-					ch := make(chan []byte, 1)
-					replyChs[i] = ch
-					// create shallow copy of task for this worker
-					t := &payload.Task{
-						Command: task.Command,
-						ReplyCh: ch,
-					}
-					wk.TaskCh <- t
-				}
+			if worker.BlockingCmds[cmd.Cmd] {
+				h.dispatchBlocking(connFd, conn, cmd)
+				continue
+			}
 
-				// Collect replies from all workers, merge arrays of keys
-				var allKeys []string
-				for _, ch := range replyChs {
-					res := <-ch
-					// decode response — resp.ParseResp? your resp package encodes.
-					// Here we assume the worker returned an array encoded with resp.Encode([]string,...)
-					// So we need to parse it back. Use resp.ParseReply helper if available,
-					// otherwise, decode a simple RESP array manually. For simplicity we use resp.DecodeBytes
-					// (You may need to adapt to your resp package.)
-
-					parsed, err := resp.Decode(res)
-					if err != nil {
-						// if a worker returned an error, send that error to client
-						conn.Write(res)
-						break
-					}
-					// parsed should be []string (or []interface{}) depending on your resp.Decode
-					if arr, ok := parsed.([]string); ok {
-						allKeys = append(allKeys, arr...)
-					} else if arrI, ok := parsed.([]interface{}); ok {
-						for _, v := range arrI {
-							if s, ok := v.(string); ok {
-								allKeys = append(allKeys, s)
-							}
-						}
-					} else {
-						// unknown decode form -- ignore or handle
-					}
-				}
+			conn.Write(h.execute(cmd))
+		}
+	}
+}
 
-				// remove duplicates (since same key should be on only one worker, duplicates unlikely)
-				// but we'll deduplicate defensively:
-				uniq := make(map[string]struct{}, len(allKeys))
-				deduped := make([]string, 0, len(allKeys))
-				for _, k := range allKeys {
-					if _, seen := uniq[k]; !seen {
-						uniq[k] = struct{}{}
-						deduped = append(deduped, k)
-					}
-				}
+// execute runs a non-blocking command and returns its encoded reply.
+func (h *IOHandler) execute(cmd *payload.Command) []byte {
+	if cmd.Cmd == "KEYS" {
+		return h.broadcastKEYS(cmd)
+	}
+	if coordinate, ok := coordinatedCmds[cmd.Cmd]; ok {
+		return coordinate(h, cmd.Args)
+	}
+
+	workerID, err := h.route(cmd)
+	if err != nil {
+		return resp.Encode(err, false)
+	}
 
-				// encode final response
-				final := resp.Encode(deduped, false)
-				conn.Write(final)
+	replyCh := make(chan []byte, 1)
+	h.Workers[workerID].TaskCh <- &payload.Task{
+		Command: cmd,
+		ReplyCh: replyCh,
+	}
+	return <-replyCh
+}
 
-				// continue to next event (we already wrote response)
-				continue
+func (h *IOHandler) broadcastKEYS(cmd *payload.Command) []byte {
+	// Broadcast to all workers and aggregate
+	// create per-worker reply channels and send tasks
+	replyChs := make([]chan []byte, len(h.Workers))
+	for i, wk := range h.Workers {
+		ch := make(chan []byte, 1)
+		replyChs[i] = ch
+		t := &payload.Task{
+			Command: cmd,
+			ReplyCh: ch,
+		}
+		wk.TaskCh <- t
+	}
+
+	// Collect replies from all workers, merge arrays of keys
+	var allKeys []string
+	var errReply []byte
+	for _, ch := range replyChs {
+		res := <-ch
+		parsed, err := resp.Decode(res)
+		if err != nil {
+			// if a worker returned an error, send that error to client
+			errReply = res
+			continue
+		}
+		if arr, ok := parsed.([]string); ok {
+			allKeys = append(allKeys, arr...)
+		} else if arrI, ok := parsed.([]interface{}); ok {
+			for _, v := range arrI {
+				if s, ok := v.(string); ok {
+					allKeys = append(allKeys, s)
+				}
 			}
+		}
+	}
+	if errReply != nil {
+		return errReply
+	}
 
-			// dispatch the command to the Worker
-			h.dispatch(task)
-			res := <-replyCh
-			conn.Write(res)
+	// remove duplicates (since same key should be on only one worker, duplicates unlikely)
+	// but we'll deduplicate defensively:
+	uniq := make(map[string]struct{}, len(allKeys))
+	deduped := make([]string, 0, len(allKeys))
+	for _, k := range allKeys {
+		if _, seen := uniq[k]; !seen {
+			uniq[k] = struct{}{}
+			deduped = append(deduped, k)
 		}
 	}
+
+	return resp.Encode(deduped, false)
 }
 
-func (h *IOHandler) dispatch(task *payload.Task) {
-	var key string
-	var workerID int
-	if len(task.Command.Args) > 0 {
-		key = task.Command.Args[0]
-		workerID = h.getPartitionID(key)
-	} else {
-		workerID = rand.Intn(h.NumWorker)
+// route picks the worker owning the command's key. Commands touching several
+// keys are only accepted when all of them live on the same worker.
+func (h *IOHandler) route(cmd *payload.Command) (int, error) {
+	keys := cmd.Args
+	if keysOf, ok := cmdKeys[cmd.Cmd]; ok {
+		keys = keysOf(cmd.Args)
+	} else if len(keys) > 1 {
+		keys = keys[:1]
+	}
+	if len(keys) == 0 {
+		return rand.Intn(h.NumWorker), nil
 	}
 
-	h.Workers[workerID].TaskCh <- task
+	workerID := h.getPartitionID(keys[0])
+	for _, key := range keys[1:] {
+		if h.getPartitionID(key) != workerID {
+			return 0, config.ErrCrossSlot
+		}
+	}
+	return workerID, nil
 }
 
 func (h *IOHandler) getPartitionID(key string) int {
@@ -201,10 +227,21 @@ func readCommandConn(conn net.Conn) (*payload.Command, error) {
 
 func (h *IOHandler) closeConn(fd int) {
 	h.mu.Lock()
-	defer h.mu.Unlock()
-
 	if conn, ok := h.Conns[fd]; ok {
 		conn.Close()
 		delete(h.Conns, fd)
 	}
+	b, blocked := h.blocked[fd]
+	delete(h.blocked, fd)
+	var task *payload.Task
+	var workerID int
+	if blocked {
+		task, workerID = b.task, b.workerID
+	}
+	h.mu.Unlock()
+
+	if task != nil {
+		close(task.Done)
+		h.Workers[workerID].Unblock(task)
+	}
 }
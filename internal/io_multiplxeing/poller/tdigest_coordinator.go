@@ -0,0 +1,83 @@
+package poller
+
+import (
+	"backend/internal/config"
+	"backend/internal/datastore"
+	"backend/internal/protocol/resp"
+	"strconv"
+	"strings"
+)
+
+// cmdTDIGESTMERGE handles TDIGEST.MERGE destination numKeys source...
+// [COMPRESSION compression] [OVERRIDE]. An existing destination is merged
+// with the sources unless OVERRIDE is given. Without COMPRESSION the result
+// keeps the destination's compression, or the largest of the sources'.
+func (h *IOHandler) cmdTDIGESTMERGE(args []string) []byte {
+	if len(args) < 3 {
+		return resp.Encode(config.ErrWrongNumberArguments, false)
+	}
+
+	dst := args[0]
+	keys, err := parseNumKeys(args[1:])
+	if err != nil {
+		return resp.Encode(err, false)
+	}
+
+	var compression float64
+	override := false
+	rest := args[2+len(keys):]
+	for i := 0; i < len(rest); i++ {
+		switch {
+		case strings.EqualFold(rest[i], "COMPRESSION") && i+1 < len(rest):
+			c, err := strconv.ParseInt(rest[i+1], 10, 32)
+			if err != nil || c <= 0 {
+				return resp.Encode(config.ErrTDigestInvalidCompression, false)
+			}
+			compression = float64(c)
+			i++
+		case strings.EqualFold(rest[i], "OVERRIDE"):
+			override = true
+		default:
+			return resp.Encode(config.ErrSyntaxError, false)
+		}
+	}
+
+	srcs := make([]*datastore.EntryTDigest, len(keys))
+	errs := make([]error, len(keys))
+	h.onKeys(keys, func(ds *datastore.Datastore, i int) {
+		srcs[i], errs[i] = ds.CloneTDigest(keys[i])
+	})
+	for i, err := range errs {
+		if err != nil {
+			return resp.Encode(err, false)
+		}
+		if srcs[i] == nil {
+			return resp.Encode(config.ErrKeyNotExist, false)
+		}
+	}
+
+	// the destination is read and written in one go on its worker, so that
+	// no TDIGEST.ADD to it in between is lost
+	h.onKey(dst, func(ds *datastore.Datastore) {
+		var dstDigest *datastore.EntryTDigest
+		if dstDigest, err = ds.CloneTDigest(dst); err != nil {
+			return
+		}
+		if dstDigest != nil && !override {
+			if compression == 0 {
+				compression = dstDigest.Compression()
+			}
+			srcs = append(srcs, dstDigest)
+		}
+		if compression == 0 {
+			for _, src := range srcs {
+				compression = max(compression, src.Compression())
+			}
+		}
+		err = ds.TDigestStore(dst, datastore.MergeTDigests(compression, srcs))
+	})
+	if err != nil {
+		return resp.Encode(err, false)
+	}
+	return config.RespOk
+}
@@ -0,0 +1,311 @@
+package poller
+
+import (
+	"backend/internal/config"
+	"backend/internal/datastore"
+	"backend/internal/protocol/resp"
+	"backend/internal/worker"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// zsetAlgebra is a parsed ZUNION, ZINTER or ZDIFF and their STORE forms.
+type zsetAlgebra struct {
+	keys       []string
+	weights    []float64
+	aggregate  func(a, b float64) float64
+	withScores bool
+}
+
+func aggregateSum(a, b float64) float64 {
+	// inf + -inf
+	if sum := a + b; !math.IsNaN(sum) {
+		return sum
+	}
+	return 0
+}
+
+// parseZSetAlgebra parses numkeys key... and the options after them, taking
+// WEIGHTS and AGGREGATE only when weighted is set and WITHSCORES only when
+// withScores is.
+func parseZSetAlgebra(args []string, weighted, withScores bool) (*zsetAlgebra, error) {
+	keys, err := parseNumKeys(args)
+	if err != nil {
+		return nil, err
+	}
+
+	za := &zsetAlgebra{keys: keys, aggregate: aggregateSum}
+	rest := args[1+len(keys):]
+	for i := 0; i < len(rest); i++ {
+		switch {
+		case weighted && strings.EqualFold(rest[i], "WEIGHTS") && i+len(keys) < len(rest):
+			za.weights = make([]float64, len(keys))
+			for j := range keys {
+				w, err := strconv.ParseFloat(rest[i+1+j], 64)
+				if err != nil || math.IsNaN(w) {
+					return nil, config.ErrWeightNotFloat
+				}
+				za.weights[j] = w
+			}
+			i += len(keys)
+		case weighted && strings.EqualFold(rest[i], "AGGREGATE") && i+1 < len(rest):
+			switch strings.ToUpper(rest[i+1]) {
+			case "SUM":
+				za.aggregate = aggregateSum
+			case "MIN":
+				za.aggregate = math.Min
+			case "MAX":
+				za.aggregate = math.Max
+			default:
+				return nil, config.ErrSyntaxError
+			}
+			i++
+		case withScores && strings.EqualFold(rest[i], "WITHSCORES"):
+			za.withScores = true
+		default:
+			return nil, config.ErrSyntaxError
+		}
+	}
+	return za, nil
+}
+
+// weighted scales a score from the i-th key by its weight, where 0 * inf is 0.
+func (za *zsetAlgebra) weighted(i int, score float64) float64 {
+	if za.weights == nil {
+		return score
+	}
+	if v := score * za.weights[i]; !math.IsNaN(v) {
+		return v
+	}
+	return 0
+}
+
+func (h *IOHandler) gatherZSets(keys []string) ([]map[string]float64, error) {
+	zsets := make([]map[string]float64, len(keys))
+	errs := make([]error, len(keys))
+	h.onKeys(keys, func(ds *datastore.Datastore, i int) {
+		zsets[i], errs[i] = ds.ZScores(keys[i])
+	})
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return zsets, nil
+}
+
+func unionZSets(za *zsetAlgebra, zsets []map[string]float64) map[string]float64 {
+	res := make(map[string]float64)
+	for i, zset := range zsets {
+		for member, score := range zset {
+			score = za.weighted(i, score)
+			if old, ok := res[member]; ok {
+				score = za.aggregate(old, score)
+			}
+			res[member] = score
+		}
+	}
+	return res
+}
+
+func interZSets(za *zsetAlgebra, zsets []map[string]float64) map[string]float64 {
+	smallest := 0
+	for i, zset := range zsets {
+		if len(zset) < len(zsets[smallest]) {
+			smallest = i
+		}
+	}
+
+	res := make(map[string]float64)
+next:
+	for member := range zsets[smallest] {
+		var score float64
+		for i, zset := range zsets {
+			s, ok := zset[member]
+			if !ok {
+				continue next
+			}
+			if i == 0 {
+				score = za.weighted(i, s)
+			} else {
+				score = za.aggregate(score, za.weighted(i, s))
+			}
+		}
+		res[member] = score
+	}
+	return res
+}
+
+// diffZSets keeps the members of the first key missing from all the others,
+// with their original scores.
+func diffZSets(za *zsetAlgebra, zsets []map[string]float64) map[string]float64 {
+	res := make(map[string]float64)
+	for member, score := range zsets[0] {
+		found := false
+		for _, zset := range zsets[1:] {
+			if _, found = zset[member]; found {
+				break
+			}
+		}
+		if !found {
+			res[member] = score
+		}
+	}
+	return res
+}
+
+type zsetAlgebraOp func(za *zsetAlgebra, zsets []map[string]float64) map[string]float64
+
+func (h *IOHandler) zsetAlgebra(za *zsetAlgebra, op zsetAlgebraOp) (map[string]float64, error) {
+	zsets, err := h.gatherZSets(za.keys)
+	if err != nil {
+		return nil, err
+	}
+	return op(za, zsets), nil
+}
+
+// zsetReply orders scores like a sorted set and flattens them into a reply.
+func zsetReply(scores map[string]float64, withScores bool) []string {
+	members := make([]string, 0, len(scores))
+	for member := range scores {
+		members = append(members, member)
+	}
+	sort.Slice(members, func(i, j int) bool {
+		a, b := scores[members[i]], scores[members[j]]
+		if a != b {
+			return a < b
+		}
+		return members[i] < members[j]
+	})
+
+	if !withScores {
+		return members
+	}
+	res := make([]string, 0, len(members)*2)
+	for _, member := range members {
+		res = append(res, member, strconv.FormatFloat(scores[member], 'f', -1, 64))
+	}
+	return res
+}
+
+func (h *IOHandler) zsetAlgebraCmd(args []string, weighted bool, op zsetAlgebraOp) []byte {
+	if len(args) < 2 {
+		return resp.Encode(config.ErrWrongNumberArguments, false)
+	}
+
+	za, err := parseZSetAlgebra(args, weighted, true)
+	if err != nil {
+		return resp.Encode(err, false)
+	}
+
+	scores, err := h.zsetAlgebra(za, op)
+	if err != nil {
+		return resp.Encode(err, false)
+	}
+	return resp.Encode(zsetReply(scores, za.withScores), false)
+}
+
+func (h *IOHandler) zsetAlgebraStoreCmd(args []string, weighted bool, op zsetAlgebraOp) []byte {
+	if len(args) < 3 {
+		return resp.Encode(config.ErrWrongNumberArguments, false)
+	}
+
+	dst := args[0]
+	za, err := parseZSetAlgebra(args[1:], weighted, false)
+	if err != nil {
+		return resp.Encode(err, false)
+	}
+
+	scores, err := h.zsetAlgebra(za, op)
+	if err != nil {
+		return resp.Encode(err, false)
+	}
+
+	var n int
+	h.onKeyWrite(dst, func(ds *datastore.Datastore) {
+		n = ds.ZStore(dst, scores)
+	})
+	return resp.Encode(n, false)
+}
+
+func (h *IOHandler) cmdZUNION(args []string) []byte {
+	return h.zsetAlgebraCmd(args, true, unionZSets)
+}
+
+func (h *IOHandler) cmdZINTER(args []string) []byte {
+	return h.zsetAlgebraCmd(args, true, interZSets)
+}
+
+func (h *IOHandler) cmdZDIFF(args []string) []byte {
+	return h.zsetAlgebraCmd(args, false, diffZSets)
+}
+
+func (h *IOHandler) cmdZUNIONSTORE(args []string) []byte {
+	return h.zsetAlgebraStoreCmd(args, true, unionZSets)
+}
+
+func (h *IOHandler) cmdZINTERSTORE(args []string) []byte {
+	return h.zsetAlgebraStoreCmd(args, true, interZSets)
+}
+
+func (h *IOHandler) cmdZDIFFSTORE(args []string) []byte {
+	return h.zsetAlgebraStoreCmd(args, false, diffZSets)
+}
+
+// cmdZRANGESTORE handles ZRANGESTORE destination source min max [BYSCORE|BYLEX]
+// [REV] [LIMIT offset count].
+func (h *IOHandler) cmdZRANGESTORE(args []string) []byte {
+	if len(args) < 4 {
+		return resp.Encode(config.ErrWrongNumberArguments, false)
+	}
+
+	dst, src := args[0], args[1]
+	q, err := worker.ParseZRangeStore(args[2:])
+	if err != nil {
+		return resp.Encode(err, false)
+	}
+
+	var scores map[string]float64
+	h.onKey(src, func(ds *datastore.Datastore) {
+		scores, err = ds.ZRangeScores(src, q)
+	})
+	if err != nil {
+		return resp.Encode(err, false)
+	}
+
+	var n int
+	h.onKeyWrite(dst, func(ds *datastore.Datastore) {
+		n = ds.ZStore(dst, scores)
+	})
+	return resp.Encode(n, false)
+}
+
+// cmdGEOSEARCHSTORE handles GEOSEARCHSTORE destination source followed by the
+// options of GEOSEARCH, with STOREDIST in place of the WITH options.
+func (h *IOHandler) cmdGEOSEARCHSTORE(args []string) []byte {
+	if len(args) < 6 {
+		return resp.Encode(config.ErrWrongNumberArguments, false)
+	}
+
+	dst, src := args[0], args[1]
+	q, distUnit, err := worker.ParseGeoSearchStore(args[2:])
+	if err != nil {
+		return resp.Encode(err, false)
+	}
+
+	var scores map[string]float64
+	h.onKey(src, func(ds *datastore.Datastore) {
+		scores, err = ds.GeoSearchScores(src, q, distUnit)
+	})
+	if err != nil {
+		return resp.Encode(err, false)
+	}
+
+	var n int
+	h.onKeyWrite(dst, func(ds *datastore.Datastore) {
+		n = ds.ZStore(dst, scores)
+	})
+	return resp.Encode(n, false)
+}
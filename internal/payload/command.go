@@ -8,4 +8,7 @@ type Command struct {
 type Task struct {
 	Command *Command
 	ReplyCh chan []byte
+	// Done is closed when the client goes away, so a worker holding a
+	// blocked task knows it no longer has anyone to reply to.
+	Done chan struct{}
 }
@@ -0,0 +1,143 @@
+package worker
+
+import (
+	"backend/internal/config"
+	"backend/internal/payload"
+	"math"
+	"strconv"
+	"time"
+)
+
+// BlockingCmds are the commands that may park the client until another
+// client pushes data. The I/O handler must not wait on their reply inline.
+var BlockingCmds = map[string]bool{
+	"BLPOP":      true,
+	"BRPOP":      true,
+	"BLMOVE":     true,
+	"XREAD":      true,
+	"XREADGROUP": true,
+	"BZPOPMIN":   true,
+	"BZPOPMAX":   true,
+}
+
+// blockedClient is a task parked on one or more keys of this worker.
+type blockedClient struct {
+	task *payload.Task
+	keys []string
+	// serve tries to satisfy the client from key; it returns false when
+	// the key still has nothing to offer.
+	serve        func(key string) ([]byte, bool)
+	timeoutReply []byte
+	timer        *time.Timer
+}
+
+// parseTimeout reads a blocking timeout in (possibly fractional) seconds.
+// Zero means block forever.
+func parseTimeout(arg string) (time.Duration, error) {
+	secs, err := strconv.ParseFloat(arg, 64)
+	if err != nil || math.IsNaN(secs) || math.IsInf(secs, 0) {
+		return 0, config.ErrTimeoutNotFloat
+	}
+	if secs < 0 {
+		return 0, config.ErrTimeoutNegative
+	}
+	return time.Duration(secs * float64(time.Second)), nil
+}
+
+// block parks task on keys until serve succeeds for one of them, the timeout
+// expires or the client disconnects. Waiters are served in FIFO order.
+func (h *Worker) block(task *payload.Task, keys []string, timeout time.Duration, serve func(key string) ([]byte, bool), timeoutReply []byte) {
+	select {
+	case <-task.Done:
+		task.ReplyCh <- timeoutReply
+		return
+	default:
+	}
+
+	bc := &blockedClient{
+		task:         task,
+		keys:         keys,
+		serve:        serve,
+		timeoutReply: timeoutReply,
+	}
+	for _, key := range keys {
+		h.waiters[key] = append(h.waiters[key], bc)
+	}
+	h.blocked[task] = bc
+
+	if timeout > 0 {
+		bc.timer = time.AfterFunc(timeout, func() {
+			h.unblockCh <- task
+		})
+	}
+}
+
+// Unblock releases a parked task with its timeout reply. It is safe to call
+// from any goroutine and is a no-op if the task has already been served.
+func (h *Worker) Unblock(task *payload.Task) {
+	h.unblockCh <- task
+}
+
+func (h *Worker) unblock(task *payload.Task) {
+	bc, ok := h.blocked[task]
+	if !ok {
+		return
+	}
+	h.removeBlocked(bc)
+	task.ReplyCh <- bc.timeoutReply
+}
+
+func (h *Worker) removeBlocked(bc *blockedClient) {
+	if bc.timer != nil {
+		bc.timer.Stop()
+	}
+	delete(h.blocked, bc.task)
+	for _, key := range bc.keys {
+		queue := h.waiters[key]
+		for i, w := range queue {
+			if w == bc {
+				queue = append(queue[:i], queue[i+1:]...)
+				break
+			}
+		}
+		if len(queue) == 0 {
+			delete(h.waiters, key)
+		} else {
+			h.waiters[key] = queue
+		}
+	}
+}
+
+// signalKeyReady marks key as having received new data so the clients
+// blocked on it get a chance to be served once the current command is done.
+func (h *Worker) signalKeyReady(key string) {
+	if len(h.waiters[key]) == 0 {
+		return
+	}
+	h.readyKeys = append(h.readyKeys, key)
+}
+
+func (h *Worker) serveReadyKeys() {
+	for len(h.readyKeys) > 0 {
+		key := h.readyKeys[0]
+		h.readyKeys = h.readyKeys[1:]
+
+		for len(h.waiters[key]) > 0 {
+			bc := h.waiters[key][0]
+			select {
+			case <-bc.task.Done:
+				h.removeBlocked(bc)
+				bc.task.ReplyCh <- bc.timeoutReply
+				continue
+			default:
+			}
+
+			res, ok := bc.serve(key)
+			if !ok {
+				break
+			}
+			h.removeBlocked(bc)
+			bc.task.ReplyCh <- res
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package worker
+
+import (
+	"backend/internal/config"
+	"backend/internal/datastore"
+	"backend/internal/protocol/resp"
+	"strconv"
+	"strings"
+)
+
+// cmdBFRESERVE handles BF.RESERVE key error_rate capacity [EXPANSION expansion] [NONSCALING].
+func (h *Worker) cmdBFRESERVE(args []string) []byte {
+	if len(args) < 3 {
+		return resp.Encode(config.ErrWrongNumberArguments, false)
+	}
+
+	key := args[0]
+	errorRate, err := strconv.ParseFloat(args[1], 64)
+	if err != nil || errorRate <= 0 || errorRate >= 1 {
+		return resp.Encode(config.ErrBFInvalidErrorRate, false)
+	}
+	capacity, err := strconv.ParseUint(args[2], 10, 64)
+	if err != nil || capacity == 0 {
+		return resp.Encode(config.ErrBFInvalidCapacity, false)
+	}
+	if capacity > datastore.BloomMaxCapacity {
+		return resp.Encode(config.ErrBFBadCapacity, false)
+	}
+
+	expansion := uint64(2)
+	var expansionSet, nonScaling bool
+	for i := 3; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "EXPANSION":
+			if i+1 == len(args) {
+				return resp.Encode(config.ErrSyntaxError, false)
+			}
+			expansion, err = strconv.ParseUint(args[i+1], 10, 64)
+			if err != nil || expansion == 0 {
+				return resp.Encode(config.ErrBFInvalidExpansion, false)
+			}
+			if expansion > datastore.BloomMaxExpansion {
+				return resp.Encode(config.ErrBFExpansionTooLarge, false)
+			}
+			expansionSet = true
+			i++
+		case "NONSCALING":
+			nonScaling = true
+		default:
+			return resp.Encode(config.ErrSyntaxError, false)
+		}
+	}
+	if nonScaling {
+		if expansionSet {
+			return resp.Encode(config.ErrBFNonScalingExpansion, false)
+		}
+		expansion = 0
+	}
+
+	ok, err := h.datastore.CreateBloom(key, errorRate, capacity, expansion)
+	if err != nil {
+		return resp.Encode(err, false)
+	}
+
+	if !ok {
+		return resp.Encode(config.ErrKeyAlreadyExists, false)
+	}
+
+	return config.RespOk
+}
+
+func boolsToInts(bs []bool) []int {
+	res := make([]int, len(bs))
+	for i, b := range bs {
+		if b {
+			res[i] = 1
+		}
+	}
+	return res
+}
+
+func (h *Worker) cmdBFADD(args []string) []byte {
+	if len(args) != 2 {
+		return resp.Encode(config.ErrWrongNumberArguments, false)
+	}
+
+	added, err := h.datastore.BloomAdd(args[0], args[1:])
+	if err != nil {
+		return resp.Encode(err, false)
+	}
+	return resp.Encode(boolsToInts(added)[0], false)
+}
+
+// cmdBFMADD replies with an error in place of each item that could not be
+// added once a non-scaling filter is full.
+func (h *Worker) cmdBFMADD(args []string) []byte {
+	if len(args) < 2 {
+		return resp.Encode(config.ErrWrongNumberArguments, false)
+	}
+
+	items := args[1:]
+	added, err := h.datastore.BloomAdd(args[0], items)
+	if err != nil && added == nil {
+		return resp.Encode(err, false)
+	}
+
+	res := make([]interface{}, len(items))
+	for i := range items {
+		if i >= len(added) {
+			res[i] = err
+		} else if added[i] {
+			res[i] = 1
+		} else {
+			res[i] = 0
+		}
+	}
+	return resp.Encode(res, false)
+}
+
+func (h *Worker) cmdBFEXISTS(args []string) []byte {
+	if len(args) != 2 {
+		return resp.Encode(config.ErrWrongNumberArguments, false)
+	}
+
+	found, err := h.datastore.BloomExists(args[0], args[1:])
+	if err != nil {
+		return resp.Encode(err, false)
+	}
+	return resp.Encode(boolsToInts(found)[0], false)
+}
+
+func (h *Worker) cmdBFMEXISTS(args []string) []byte {
+	if len(args) < 2 {
+		return resp.Encode(config.ErrWrongNumberArguments, false)
+	}
+
+	found, err := h.datastore.BloomExists(args[0], args[1:])
+	if err != nil {
+		return resp.Encode(err, false)
+	}
+	return resp.Encode(boolsToInts(found), false)
+}
+
+func (h *Worker) cmdBFINFO(args []string) []byte {
+	if len(args) != 1 {
+		return resp.Encode(config.ErrWrongNumberArguments, false)
+	}
+
+	info, err := h.datastore.BloomInfo(args[0])
+	if err != nil {
+		return resp.Encode(err, false)
+	}
+
+	return resp.Encode([]any{
+		"Capacity", info.Capacity,
+		"Size", info.Size,
+		"Number of filters", info.Filters,
+		"Number of items inserted", info.Items,
+		"Expansion rate", info.Expansion,
+	}, false)
+}
@@ -2,28 +2,67 @@ package worker
 
 import (
 	"backend/internal/config"
+	"backend/internal/datastore"
 	"backend/internal/protocol/resp"
 	"errors"
 	"fmt"
-	"math"
 	"strconv"
+	"strings"
+	"time"
 )
 
+// parseCMSOptions parses the [CONSERVATIVE] [COUNTER64] flags of CMS.INITBYDIM
+// and CMS.INITBYPROB.
+func parseCMSOptions(args []string) (datastore.CMSOptions, error) {
+	var opts datastore.CMSOptions
+	for _, arg := range args {
+		switch strings.ToUpper(arg) {
+		case "CONSERVATIVE":
+			opts.Conservative = true
+		case "COUNTER64":
+			opts.Counter64 = true
+		default:
+			return opts, config.ErrSyntaxError
+		}
+	}
+	return opts, nil
+}
+
+// parseCMSDims parses the width and depth of CMS.INITBYDIM and
+// CMS.INITBYWINDOW. Both must be positive.
+func parseCMSDims(widthArg, depthArg string) (uint32, uint32, error) {
+	width, err := strconv.ParseUint(widthArg, 10, 32)
+	if err != nil {
+		return 0, 0, fmt.Errorf("width must be a integer number %s", widthArg)
+	}
+	if width == 0 {
+		return 0, 0, config.ErrCMSInvalidWidth
+	}
+	depth, err := strconv.ParseUint(depthArg, 10, 32)
+	if err != nil {
+		return 0, 0, fmt.Errorf("height must be a integer number %s", depthArg)
+	}
+	if depth == 0 {
+		return 0, 0, config.ErrCMSInvalidDepth
+	}
+	return uint32(width), uint32(depth), nil
+}
+
 func (h *Worker) cmdCMSINITBYDIM(args []string) []byte {
-	if len(args) != 3 {
+	if len(args) < 3 {
 		return resp.Encode(config.ErrWrongNumberArguments, false)
 	}
 	key := args[0]
-	width, err := strconv.ParseUint(args[1], 10, 32)
+	width, height, err := parseCMSDims(args[1], args[2])
 	if err != nil {
-		return resp.Encode(fmt.Errorf("width must be a integer number %s", args[1]), false)
+		return resp.Encode(err, false)
 	}
-	height, err := strconv.ParseUint(args[2], 10, 32)
+	opts, err := parseCMSOptions(args[3:])
 	if err != nil {
-		return resp.Encode(fmt.Errorf("height must be a integer number %s", args[1]), false)
+		return resp.Encode(err, false)
 	}
 
-	ok, err := h.datastore.CreateCMS(key, uint32(width), uint32(height))
+	ok, err := h.datastore.CreateCMS(key, width, height, opts)
 	if err != nil {
 		return resp.Encode(err, false)
 	}
@@ -36,7 +75,7 @@ func (h *Worker) cmdCMSINITBYDIM(args []string) []byte {
 }
 
 func (h *Worker) cmdCMSINITBYPROB(args []string) []byte {
-	if len(args) != 3 {
+	if len(args) < 3 {
 		return resp.Encode(config.ErrWrongNumberArguments, false)
 	}
 	key := args[0]
@@ -54,8 +93,49 @@ func (h *Worker) cmdCMSINITBYPROB(args []string) []byte {
 	if probability >= 1 || probability <= 0 {
 		return resp.Encode(errors.New("CMS: invalid prob value"), false)
 	}
+	opts, err := parseCMSOptions(args[3:])
+	if err != nil {
+		return resp.Encode(err, false)
+	}
 
-	ok, err := h.datastore.CreateCMSByProb(key, errRate, probability)
+	ok, err := h.datastore.CreateCMSByProb(key, errRate, probability, opts)
+	if err != nil {
+		return resp.Encode(err, false)
+	}
+
+	if !ok {
+		return resp.Encode(config.ErrKeyAlreadyExists, false)
+	}
+
+	return config.RespOk
+}
+
+// cmdCMSINITBYWINDOW creates a sketch counting over the last window
+// milliseconds, advancing every granularity milliseconds.
+func (h *Worker) cmdCMSINITBYWINDOW(args []string) []byte {
+	if len(args) < 5 {
+		return resp.Encode(config.ErrWrongNumberArguments, false)
+	}
+	key := args[0]
+	width, height, err := parseCMSDims(args[1], args[2])
+	if err != nil {
+		return resp.Encode(err, false)
+	}
+	window, err := strconv.ParseInt(args[3], 10, 64)
+	if err != nil {
+		return resp.Encode(config.ErrValueNotIntegerOrOutOfRange, false)
+	}
+	granularity, err := strconv.ParseInt(args[4], 10, 64)
+	if err != nil {
+		return resp.Encode(config.ErrValueNotIntegerOrOutOfRange, false)
+	}
+	opts, err := parseCMSOptions(args[5:])
+	if err != nil {
+		return resp.Encode(err, false)
+	}
+
+	ok, err := h.datastore.CreateWindowedCMS(key, width, height, opts,
+		time.Duration(window)*time.Millisecond, time.Duration(granularity)*time.Millisecond)
 	if err != nil {
 		return resp.Encode(err, false)
 	}
@@ -76,15 +156,15 @@ func (h *Worker) cmdCMSINCRBY(args []string) []byte {
 	var res []string
 	for i := 1; i < len(args); i += 2 {
 		item := args[i]
-		value, err := strconv.ParseUint(args[i+1], 10, 32)
+		value, err := strconv.ParseUint(args[i+1], 10, 64)
 		if err != nil {
 			return resp.Encode(fmt.Errorf("increment must be a non negative integer number %s", args[1]), false)
 		}
-		count, err := h.datastore.IncrBy(key, item, uint32(value))
+		count, overflow, err := h.datastore.IncrBy(key, item, value)
 		if err != nil {
 			return resp.Encode(err, false)
 		}
-		if count == math.MaxUint32 {
+		if overflow {
 			res = append(res, "CMS: INCRBY overflow")
 			continue
 		}
@@ -100,24 +180,60 @@ func (h *Worker) cmdCMSQUERY(args []string) []byte {
 
 	key := args[0]
 	items := args[1:]
-	res, err := h.datastore.Query(key, items)
+	counts, err := h.datastore.Query(key, items)
 	if err != nil {
 		return resp.Encode(err, false)
 	}
 
+	res := make([]interface{}, len(counts))
+	for i, count := range counts {
+		res[i] = count
+	}
 	return resp.Encode(res, false)
 }
 
-func (h *Worker) cmdINFO(args []string) []byte {
-	if len(args) > 1 {
+func (h *Worker) cmdCMSINFO(args []string) []byte {
+	if len(args) != 1 {
 		return resp.Encode(config.ErrWrongNumberArguments, false)
 	}
 
 	key := args[0]
-	w, d, err := h.datastore.Info(key)
+	info, err := h.datastore.Info(key)
 	if err != nil {
 		return resp.Encode(err, false)
 	}
 
-	return resp.Encode([]any{"width", w, "depth", d}, false)
+	counterBits := 32
+	if info.Counter64 {
+		counterBits = 64
+	}
+	conservative := 0
+	if info.Conservative {
+		conservative = 1
+	}
+	res := []any{
+		"width", info.Width,
+		"depth", info.Depth,
+		"count", info.Count,
+		"conservative", conservative,
+		"counter bits", counterBits,
+	}
+	if info.Window > 0 {
+		res = append(res,
+			"window ms", info.Window.Milliseconds(),
+			"granularity ms", info.Granularity.Milliseconds())
+	}
+	return resp.Encode(res, false)
+}
+
+func (h *Worker) cmdCMSRESET(args []string) []byte {
+	if len(args) != 1 {
+		return resp.Encode(config.ErrWrongNumberArguments, false)
+	}
+
+	if err := h.datastore.Reset(args[0]); err != nil {
+		return resp.Encode(err, false)
+	}
+
+	return config.RespOk
 }
@@ -0,0 +1,166 @@
+package worker
+
+import (
+	"backend/internal/config"
+	"backend/internal/datastore"
+	"backend/internal/protocol/resp"
+	"strconv"
+	"strings"
+)
+
+// parseCFUint parses a CF.RESERVE setting in [min, max].
+func parseCFUint(arg string, min, max uint64, errBad error) (uint64, error) {
+	v, err := strconv.ParseUint(arg, 10, 64)
+	if err != nil || v < min || v > max {
+		return 0, errBad
+	}
+	return v, nil
+}
+
+// cmdCFRESERVE handles CF.RESERVE key capacity [BUCKETSIZE bucketsize]
+// [MAXITERATIONS maxiterations] [EXPANSION expansion].
+func (h *Worker) cmdCFRESERVE(args []string) []byte {
+	if len(args) < 2 || len(args)%2 != 0 {
+		return resp.Encode(config.ErrWrongNumberArguments, false)
+	}
+
+	key := args[0]
+	opts := datastore.CuckooOptions{
+		BucketSize:    datastore.CuckooDefaultBucketSize,
+		MaxIterations: datastore.CuckooDefaultMaxIterations,
+		Expansion:     datastore.CuckooDefaultExpansion,
+	}
+	var err error
+	if opts.Capacity, err = parseCFUint(args[1], 1, datastore.CuckooMaxCapacity, config.ErrCFBadCapacity); err != nil {
+		return resp.Encode(err, false)
+	}
+	for i := 2; i < len(args); i += 2 {
+		switch strings.ToUpper(args[i]) {
+		case "BUCKETSIZE":
+			opts.BucketSize, err = parseCFUint(args[i+1], 1, datastore.CuckooMaxBucketSize, config.ErrCFBadBucketSize)
+		case "MAXITERATIONS":
+			opts.MaxIterations, err = parseCFUint(args[i+1], 1, datastore.CuckooMaxIterations, config.ErrCFBadMaxIterations)
+		case "EXPANSION":
+			opts.Expansion, err = parseCFUint(args[i+1], 0, datastore.CuckooMaxExpansion, config.ErrCFBadExpansion)
+		default:
+			err = config.ErrSyntaxError
+		}
+		if err != nil {
+			return resp.Encode(err, false)
+		}
+	}
+
+	ok, err := h.datastore.CreateCuckoo(key, opts)
+	if err != nil {
+		return resp.Encode(err, false)
+	}
+
+	if !ok {
+		return resp.Encode(config.ErrKeyAlreadyExists, false)
+	}
+
+	return config.RespOk
+}
+
+func (h *Worker) cfAdd(args []string, nx bool) []byte {
+	if len(args) != 2 {
+		return resp.Encode(config.ErrWrongNumberArguments, false)
+	}
+
+	res, err := h.datastore.CuckooInsert(args[0], args[1:], datastore.CuckooInsertOptions{NX: nx})
+	if err != nil {
+		return resp.Encode(err, false)
+	}
+	if res[0] < 0 {
+		return resp.Encode(config.ErrCFFilterFull, false)
+	}
+	return resp.Encode(res[0], false)
+}
+
+func (h *Worker) cmdCFADD(args []string) []byte {
+	return h.cfAdd(args, false)
+}
+
+func (h *Worker) cmdCFADDNX(args []string) []byte {
+	return h.cfAdd(args, true)
+}
+
+// cmdCFINSERT handles CF.INSERT key [CAPACITY capacity] [NOCREATE] ITEMS item...
+func (h *Worker) cmdCFINSERT(args []string) []byte {
+	if len(args) < 3 {
+		return resp.Encode(config.ErrWrongNumberArguments, false)
+	}
+
+	var opts datastore.CuckooInsertOptions
+	var items []string
+	var err error
+	for i := 1; i < len(args) && items == nil; i++ {
+		switch strings.ToUpper(args[i]) {
+		case "CAPACITY":
+			if i+1 == len(args) {
+				return resp.Encode(config.ErrSyntaxError, false)
+			}
+			if opts.Capacity, err = parseCFUint(args[i+1], 1, datastore.CuckooMaxCapacity, config.ErrCFBadCapacity); err != nil {
+				return resp.Encode(err, false)
+			}
+			i++
+		case "NOCREATE":
+			opts.NoCreate = true
+		case "ITEMS":
+			items = args[i+1:]
+		default:
+			return resp.Encode(config.ErrSyntaxError, false)
+		}
+	}
+	if len(items) == 0 {
+		return resp.Encode(config.ErrWrongNumberArguments, false)
+	}
+
+	res, err := h.datastore.CuckooInsert(args[0], items, opts)
+	if err != nil {
+		return resp.Encode(err, false)
+	}
+	return resp.Encode(res, false)
+}
+
+func (h *Worker) cmdCFEXISTS(args []string) []byte {
+	if len(args) != 2 {
+		return resp.Encode(config.ErrWrongNumberArguments, false)
+	}
+
+	ok, err := h.datastore.CuckooExists(args[0], args[1])
+	if err != nil {
+		return resp.Encode(err, false)
+	}
+	if ok {
+		return resp.Encode(1, false)
+	}
+	return resp.Encode(0, false)
+}
+
+func (h *Worker) cmdCFDEL(args []string) []byte {
+	if len(args) != 2 {
+		return resp.Encode(config.ErrWrongNumberArguments, false)
+	}
+
+	ok, err := h.datastore.CuckooDel(args[0], args[1])
+	if err != nil {
+		return resp.Encode(err, false)
+	}
+	if ok {
+		return resp.Encode(1, false)
+	}
+	return resp.Encode(0, false)
+}
+
+func (h *Worker) cmdCFCOUNT(args []string) []byte {
+	if len(args) != 2 {
+		return resp.Encode(config.ErrWrongNumberArguments, false)
+	}
+
+	n, err := h.datastore.CuckooCount(args[0], args[1])
+	if err != nil {
+		return resp.Encode(err, false)
+	}
+	return resp.Encode(n, false)
+}
@@ -0,0 +1,326 @@
+package worker
+
+import (
+	"backend/internal/config"
+	"backend/internal/datastore"
+	"backend/internal/protocol/resp"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// geoUnits are the distance units in meters.
+var geoUnits = map[string]float64{
+	"m":  1,
+	"km": 1000,
+	"ft": 0.3048,
+	"mi": 1609.34,
+}
+
+func parseGeoUnit(arg string) (float64, error) {
+	unit, ok := geoUnits[strings.ToLower(arg)]
+	if !ok {
+		return 0, config.ErrGeoUnsupportedUnit
+	}
+	return unit, nil
+}
+
+func parseGeoFloat(arg string) (float64, error) {
+	v, err := strconv.ParseFloat(arg, 64)
+	if err != nil || math.IsNaN(v) {
+		return 0, config.ErrValueNotFloat
+	}
+	return v, nil
+}
+
+// parseLonLat parses a longitude and latitude pair and checks that it can be
+// geohash encoded.
+func parseLonLat(lonArg, latArg string) (float64, float64, error) {
+	lon, err := parseGeoFloat(lonArg)
+	if err != nil {
+		return 0, 0, err
+	}
+	lat, err := parseGeoFloat(latArg)
+	if err != nil {
+		return 0, 0, err
+	}
+	if lon < datastore.GeoLonMin || lon > datastore.GeoLonMax || lat < datastore.GeoLatMin || lat > datastore.GeoLatMax {
+		return 0, 0, fmt.Errorf(config.ERROR_GEO_INVALID_LON_LAT, lon, lat)
+	}
+	return lon, lat, nil
+}
+
+func formatGeoFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+func formatGeoDist(meters, unit float64) string {
+	return strconv.FormatFloat(meters/unit, 'f', 4, 64)
+}
+
+func (h *Worker) cmdGEOADD(args []string) []byte {
+	if len(args) < 4 {
+		return resp.Encode(config.ErrWrongNumberArguments, false)
+	}
+	key := args[0]
+
+	var opts datastore.ZAddOptions
+	i := 1
+flags:
+	for ; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "NX":
+			opts.NX = true
+		case "XX":
+			opts.XX = true
+		case "CH":
+			opts.CH = true
+		default:
+			break flags
+		}
+	}
+	if opts.NX && opts.XX {
+		return resp.Encode(config.ErrZAddXXAndNX, false)
+	}
+
+	rest := args[i:]
+	if len(rest) == 0 || len(rest)%3 != 0 {
+		return resp.Encode(config.ErrSyntaxError, false)
+	}
+	points := make([]datastore.GeoPoint, len(rest)/3)
+	for j := range points {
+		lon, lat, err := parseLonLat(rest[3*j], rest[3*j+1])
+		if err != nil {
+			return resp.Encode(err, false)
+		}
+		points[j] = datastore.GeoPoint{Lon: lon, Lat: lat, Member: rest[3*j+2]}
+	}
+
+	rs, err := h.datastore.GeoAdd(key, opts, points)
+	if err != nil {
+		return resp.Encode(err, false)
+	}
+	h.signalKeyReady(key)
+
+	return resp.Encode(rs, false)
+}
+
+func (h *Worker) cmdGEODIST(args []string) []byte {
+	if len(args) != 3 && len(args) != 4 {
+		return resp.Encode(config.ErrWrongNumberArguments, false)
+	}
+
+	unit := 1.0
+	if len(args) == 4 {
+		var err error
+		if unit, err = parseGeoUnit(args[3]); err != nil {
+			return resp.Encode(err, false)
+		}
+	}
+
+	dist, ok, err := h.datastore.GeoDist(args[0], args[1], args[2])
+	if err != nil {
+		return resp.Encode(err, false)
+	}
+	if !ok {
+		return config.RespNil
+	}
+
+	return resp.Encode(formatGeoDist(dist, unit), false)
+}
+
+func (h *Worker) cmdGEOPOS(args []string) []byte {
+	if len(args) < 1 {
+		return resp.Encode(config.ErrWrongNumberArguments, false)
+	}
+
+	points, found, err := h.datastore.GeoPos(args[0], args[1:])
+	if err != nil {
+		return resp.Encode(err, false)
+	}
+
+	res := make([]interface{}, len(points))
+	for i, p := range points {
+		if found[i] {
+			res[i] = []string{formatGeoFloat(p.Lon), formatGeoFloat(p.Lat)}
+		}
+	}
+	return resp.Encode(res, false)
+}
+
+func (h *Worker) cmdGEOHASH(args []string) []byte {
+	if len(args) < 1 {
+		return resp.Encode(config.ErrWrongNumberArguments, false)
+	}
+
+	hashes, found, err := h.datastore.GeoHash(args[0], args[1:])
+	if err != nil {
+		return resp.Encode(err, false)
+	}
+
+	res := make([]interface{}, len(hashes))
+	for i, hash := range hashes {
+		if found[i] {
+			res[i] = hash
+		}
+	}
+	return resp.Encode(res, false)
+}
+
+// geoSearchArgs is a parsed GEOSEARCH or GEOSEARCHSTORE.
+type geoSearchArgs struct {
+	query                         datastore.GeoQuery
+	unit                          float64
+	withCoord, withDist, withHash bool
+	storeDist                     bool
+}
+
+// parseGeoSearch parses the arguments following the key(s) of GEOSEARCH,
+// accepting the WITH options for GEOSEARCH and STOREDIST for GEOSEARCHSTORE.
+func parseGeoSearch(args []string, store bool) (*geoSearchArgs, error) {
+	g := &geoSearchArgs{}
+	q := &g.query
+	var fromMember, fromLonLat, byRadius, byBox bool
+	var err error
+
+	for i := 0; i < len(args); i++ {
+		arg := strings.ToUpper(args[i])
+		switch {
+		case arg == "FROMMEMBER" && i+1 < len(args) && !fromMember && !fromLonLat:
+			q.Member = args[i+1]
+			fromMember = true
+			i++
+		case arg == "FROMLONLAT" && i+2 < len(args) && !fromMember && !fromLonLat:
+			if q.Lon, q.Lat, err = parseLonLat(args[i+1], args[i+2]); err != nil {
+				return nil, err
+			}
+			fromLonLat = true
+			i += 2
+		case arg == "BYRADIUS" && i+2 < len(args) && !byRadius && !byBox:
+			if q.Radius, err = parseGeoFloat(args[i+1]); err != nil {
+				return nil, err
+			}
+			if q.Radius < 0 {
+				return nil, config.ErrGeoRadiusNegative
+			}
+			if g.unit, err = parseGeoUnit(args[i+2]); err != nil {
+				return nil, err
+			}
+			q.Radius *= g.unit
+			byRadius = true
+			i += 2
+		case arg == "BYBOX" && i+3 < len(args) && !byRadius && !byBox:
+			if q.Width, err = parseGeoFloat(args[i+1]); err != nil {
+				return nil, err
+			}
+			if q.Height, err = parseGeoFloat(args[i+2]); err != nil {
+				return nil, err
+			}
+			if q.Width < 0 || q.Height < 0 {
+				return nil, config.ErrGeoBoxNegative
+			}
+			if g.unit, err = parseGeoUnit(args[i+3]); err != nil {
+				return nil, err
+			}
+			q.Width *= g.unit
+			q.Height *= g.unit
+			q.ByBox, byBox = true, true
+			i += 3
+		case arg == "ASC":
+			q.Sort = 1
+		case arg == "DESC":
+			q.Sort = -1
+		case arg == "COUNT" && i+1 < len(args):
+			if q.Count, err = strconv.Atoi(args[i+1]); err != nil {
+				return nil, config.ErrValueNotIntegerOrOutOfRange
+			}
+			if q.Count <= 0 {
+				return nil, config.ErrGeoCountNotPositive
+			}
+			i++
+			if i+1 < len(args) && strings.EqualFold(args[i+1], "ANY") {
+				q.Any = true
+				i++
+			}
+		case arg == "ANY":
+			return nil, config.ErrGeoAnyWithoutCount
+		case arg == "WITHCOORD" && !store:
+			g.withCoord = true
+		case arg == "WITHDIST" && !store:
+			g.withDist = true
+		case arg == "WITHHASH" && !store:
+			g.withHash = true
+		case arg == "STOREDIST" && store:
+			g.storeDist = true
+		default:
+			return nil, config.ErrSyntaxError
+		}
+	}
+
+	if !fromMember && !fromLonLat {
+		return nil, config.ErrGeoNeedFrom
+	}
+	if !byRadius && !byBox {
+		return nil, config.ErrGeoNeedBy
+	}
+	// the nearest matches are wanted unless any will do
+	if q.Count > 0 && !q.Any && q.Sort == 0 {
+		q.Sort = 1
+	}
+	return g, nil
+}
+
+func (h *Worker) cmdGEOSEARCH(args []string) []byte {
+	if len(args) < 5 {
+		return resp.Encode(config.ErrWrongNumberArguments, false)
+	}
+
+	g, err := parseGeoSearch(args[1:], false)
+	if err != nil {
+		return resp.Encode(err, false)
+	}
+
+	matches, err := h.datastore.GeoSearch(args[0], g.query)
+	if err != nil {
+		return resp.Encode(err, false)
+	}
+
+	if !g.withCoord && !g.withDist && !g.withHash {
+		res := make([]string, len(matches))
+		for i, m := range matches {
+			res[i] = m.Member
+		}
+		return resp.Encode(res, false)
+	}
+
+	res := make([]interface{}, len(matches))
+	for i, m := range matches {
+		item := []interface{}{m.Member}
+		if g.withDist {
+			item = append(item, formatGeoDist(m.Dist, g.unit))
+		}
+		if g.withHash {
+			item = append(item, m.Hash)
+		}
+		if g.withCoord {
+			item = append(item, []string{formatGeoFloat(m.Lon), formatGeoFloat(m.Lat)})
+		}
+		res[i] = item
+	}
+	return resp.Encode(res, false)
+}
+
+// ParseGeoSearchStore parses the arguments following the keys of
+// GEOSEARCHSTORE, which the coordinator runs. It returns the query and the
+// unit of the distances to store, 0 to store geohashes.
+func ParseGeoSearchStore(args []string) (datastore.GeoQuery, float64, error) {
+	g, err := parseGeoSearch(args, true)
+	if err != nil {
+		return datastore.GeoQuery{}, 0, err
+	}
+	if g.storeDist {
+		return g.query, g.unit, nil
+	}
+	return g.query, 0, nil
+}
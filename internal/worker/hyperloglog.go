@@ -0,0 +1,21 @@
+package worker
+
+import (
+	"backend/internal/config"
+	"backend/internal/protocol/resp"
+)
+
+func (h *Worker) cmdPFADD(args []string) []byte {
+	if len(args) < 1 {
+		return resp.Encode(config.ErrWrongNumberArguments, false)
+	}
+
+	changed, err := h.datastore.PFAdd(args[0], args[1:])
+	if err != nil {
+		return resp.Encode(err, false)
+	}
+	if changed {
+		return resp.Encode(1, false)
+	}
+	return resp.Encode(0, false)
+}
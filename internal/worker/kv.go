@@ -168,3 +168,22 @@ func (h *Worker) cmdDel(args []string) []byte {
 
 	return resp.Encode(strconv.Itoa(count), true)
 }
+
+func (h *Worker) cmdOBJECT(args []string) []byte {
+	if len(args) < 1 {
+		return resp.Encode(config.ErrWrongNumberArguments, false)
+	}
+	if !strings.EqualFold(args[0], "ENCODING") {
+		return resp.Encode(config.ErrUnknownSubcommand, false)
+	}
+	if len(args) != 2 {
+		return resp.Encode(config.ErrWrongNumberArguments, false)
+	}
+
+	enc, ok := h.datastore.ObjectEncoding(args[1])
+	if !ok {
+		return config.RespNil
+	}
+
+	return resp.Encode(enc, false)
+}
@@ -0,0 +1,250 @@
+package worker
+
+import (
+	"backend/internal/config"
+	"backend/internal/payload"
+	"backend/internal/protocol/resp"
+	"strconv"
+	"strings"
+)
+
+func (h *Worker) cmdLPUSH(args []string) []byte {
+	if len(args) < 2 {
+		return resp.Encode(config.ErrWrongNumberArguments, false)
+	}
+
+	key := args[0]
+	n, err := h.datastore.LPush(key, args[1:])
+	if err != nil {
+		return resp.Encode(err, false)
+	}
+	h.signalKeyReady(key)
+
+	return resp.Encode(n, false)
+}
+
+func (h *Worker) cmdRPUSH(args []string) []byte {
+	if len(args) < 2 {
+		return resp.Encode(config.ErrWrongNumberArguments, false)
+	}
+
+	key := args[0]
+	n, err := h.datastore.RPush(key, args[1:])
+	if err != nil {
+		return resp.Encode(err, false)
+	}
+	h.signalKeyReady(key)
+
+	return resp.Encode(n, false)
+}
+
+func (h *Worker) cmdLPOP(args []string) []byte {
+	return h.popCmd(args, true)
+}
+
+func (h *Worker) cmdRPOP(args []string) []byte {
+	return h.popCmd(args, false)
+}
+
+func (h *Worker) popCmd(args []string, left bool) []byte {
+	if len(args) < 1 || len(args) > 2 {
+		return resp.Encode(config.ErrWrongNumberArguments, false)
+	}
+
+	key := args[0]
+	count := 1
+	if len(args) == 2 {
+		n, err := strconv.Atoi(args[1])
+		if err != nil {
+			return resp.Encode(config.ErrValueNotIntegerOrOutOfRange, false)
+		}
+		if n < 0 {
+			return resp.Encode(config.ErrValueMustBePositive, false)
+		}
+		count = n
+	}
+
+	var res []string
+	var err error
+	if left {
+		res, err = h.datastore.LPop(key, count)
+	} else {
+		res, err = h.datastore.RPop(key, count)
+	}
+	if err != nil {
+		return resp.Encode(err, false)
+	}
+
+	// without a count the reply is a single bulk string
+	if len(args) == 1 {
+		if len(res) == 0 {
+			return config.RespNil
+		}
+		return resp.Encode(res[0], false)
+	}
+	if res == nil {
+		return config.RespNilArray
+	}
+	return resp.Encode(res, false)
+}
+
+func (h *Worker) cmdLLEN(args []string) []byte {
+	if len(args) != 1 {
+		return resp.Encode(config.ErrWrongNumberArguments, false)
+	}
+
+	n, err := h.datastore.LLen(args[0])
+	if err != nil {
+		return resp.Encode(err, false)
+	}
+
+	return resp.Encode(n, false)
+}
+
+func (h *Worker) cmdLRANGE(args []string) []byte {
+	if len(args) != 3 {
+		return resp.Encode(config.ErrWrongNumberArguments, false)
+	}
+
+	key := args[0]
+	start, err := strconv.Atoi(args[1])
+	if err != nil {
+		return resp.Encode(config.ErrValueNotIntegerOrOutOfRange, false)
+	}
+	stop, err := strconv.Atoi(args[2])
+	if err != nil {
+		return resp.Encode(config.ErrValueNotIntegerOrOutOfRange, false)
+	}
+
+	res, err := h.datastore.LRange(key, start, stop)
+	if err != nil {
+		return resp.Encode(err, false)
+	}
+
+	return resp.Encode(res, false)
+}
+
+// parseDirection parses a LEFT|RIGHT argument and reports whether it is LEFT.
+func parseDirection(arg string) (bool, bool) {
+	switch strings.ToUpper(arg) {
+	case "LEFT":
+		return true, true
+	case "RIGHT":
+		return false, true
+	}
+	return false, false
+}
+
+func (h *Worker) cmdLMOVE(args []string) []byte {
+	if len(args) != 4 {
+		return resp.Encode(config.ErrWrongNumberArguments, false)
+	}
+
+	src, dst := args[0], args[1]
+	fromLeft, ok := parseDirection(args[2])
+	if !ok {
+		return resp.Encode(config.ErrSyntaxError, false)
+	}
+	toLeft, ok := parseDirection(args[3])
+	if !ok {
+		return resp.Encode(config.ErrSyntaxError, false)
+	}
+
+	val, ok, err := h.datastore.LMove(src, dst, fromLeft, toLeft)
+	if err != nil {
+		return resp.Encode(err, false)
+	}
+	if !ok {
+		return config.RespNil
+	}
+	h.signalKeyReady(dst)
+
+	return resp.Encode(val, false)
+}
+
+func (h *Worker) cmdBLPOP(task *payload.Task) []byte {
+	return h.blockingPopCmd(task, true)
+}
+
+func (h *Worker) cmdBRPOP(task *payload.Task) []byte {
+	return h.blockingPopCmd(task, false)
+}
+
+func (h *Worker) blockingPopCmd(task *payload.Task, left bool) []byte {
+	args := task.Command.Args
+	if len(args) < 2 {
+		return resp.Encode(config.ErrWrongNumberArguments, false)
+	}
+
+	keys := args[:len(args)-1]
+	timeout, err := parseTimeout(args[len(args)-1])
+	if err != nil {
+		return resp.Encode(err, false)
+	}
+
+	serve := func(key string) ([]byte, bool) {
+		var res []string
+		var err error
+		if left {
+			res, err = h.datastore.LPop(key, 1)
+		} else {
+			res, err = h.datastore.RPop(key, 1)
+		}
+		if err != nil {
+			return resp.Encode(err, false), true
+		}
+		if len(res) == 0 {
+			return nil, false
+		}
+		return resp.Encode([]string{key, res[0]}, false), true
+	}
+
+	for _, key := range keys {
+		if res, ok := serve(key); ok {
+			return res
+		}
+	}
+
+	h.block(task, keys, timeout, serve, config.RespNilArray)
+	return nil
+}
+
+func (h *Worker) cmdBLMOVE(task *payload.Task) []byte {
+	args := task.Command.Args
+	if len(args) != 5 {
+		return resp.Encode(config.ErrWrongNumberArguments, false)
+	}
+
+	src, dst := args[0], args[1]
+	fromLeft, ok := parseDirection(args[2])
+	if !ok {
+		return resp.Encode(config.ErrSyntaxError, false)
+	}
+	toLeft, ok := parseDirection(args[3])
+	if !ok {
+		return resp.Encode(config.ErrSyntaxError, false)
+	}
+	timeout, err := parseTimeout(args[4])
+	if err != nil {
+		return resp.Encode(err, false)
+	}
+
+	serve := func(key string) ([]byte, bool) {
+		val, ok, err := h.datastore.LMove(src, dst, fromLeft, toLeft)
+		if err != nil {
+			return resp.Encode(err, false), true
+		}
+		if !ok {
+			return nil, false
+		}
+		h.signalKeyReady(dst)
+		return resp.Encode(val, false), true
+	}
+
+	if res, ok := serve(src); ok {
+		return res
+	}
+
+	h.block(task, []string{src}, timeout, serve, config.RespNil)
+	return nil
+}
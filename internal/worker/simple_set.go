@@ -3,6 +3,8 @@ package worker
 import (
 	"backend/internal/config"
 	"backend/internal/protocol/resp"
+	"strconv"
+	"strings"
 )
 
 func (h *Worker) cmdSADD(args []string) []byte {
@@ -60,3 +62,149 @@ func (h *Worker) cmdSMIsMember(args []string) []byte {
 
 	return resp.Encode(rs, false)
 }
+
+func (h *Worker) cmdSREM(args []string) []byte {
+	if len(args) < 2 {
+		return resp.Encode(config.ErrWrongNumberArguments, false)
+	}
+
+	key, members := args[0], args[1:]
+	rs, err := h.datastore.SRem(key, members)
+	if err != nil {
+		return resp.Encode(err, false)
+	}
+
+	return resp.Encode(rs, false)
+}
+
+func (h *Worker) cmdSCARD(args []string) []byte {
+	if len(args) != 1 {
+		return resp.Encode(config.ErrWrongNumberArguments, false)
+	}
+
+	rs, err := h.datastore.SCard(args[0])
+	if err != nil {
+		return resp.Encode(err, false)
+	}
+
+	return resp.Encode(rs, false)
+}
+
+func (h *Worker) cmdSPOP(args []string) []byte {
+	if len(args) < 1 || len(args) > 2 {
+		return resp.Encode(config.ErrWrongNumberArguments, false)
+	}
+
+	key := args[0]
+	if len(args) == 1 {
+		rs, err := h.datastore.SPop(key, 1)
+		if err != nil {
+			return resp.Encode(err, false)
+		}
+		if len(rs) == 0 {
+			return config.RespNil
+		}
+		return resp.Encode(rs[0], false)
+	}
+
+	count, err := strconv.Atoi(args[1])
+	if err != nil {
+		return resp.Encode(config.ErrValueNotIntegerOrOutOfRange, false)
+	}
+	if count < 0 {
+		return resp.Encode(config.ErrValueMustBePositive, false)
+	}
+	rs, err := h.datastore.SPop(key, count)
+	if err != nil {
+		return resp.Encode(err, false)
+	}
+	if rs == nil {
+		rs = []string{}
+	}
+
+	return resp.Encode(rs, false)
+}
+
+func (h *Worker) cmdSRANDMEMBER(args []string) []byte {
+	if len(args) < 1 || len(args) > 2 {
+		return resp.Encode(config.ErrWrongNumberArguments, false)
+	}
+
+	key := args[0]
+	if len(args) == 1 {
+		rs, err := h.datastore.SRandMember(key, 1)
+		if err != nil {
+			return resp.Encode(err, false)
+		}
+		if len(rs) == 0 {
+			return config.RespNil
+		}
+		return resp.Encode(rs[0], false)
+	}
+
+	// a negative count asks for -count members, repeats allowed, so it
+	// alone sets the size of the reply
+	count, err := strconv.Atoi(args[1])
+	if err != nil || count < -config.MaxRandCount {
+		return resp.Encode(config.ErrValueNotIntegerOrOutOfRange, false)
+	}
+	rs, err := h.datastore.SRandMember(key, count)
+	if err != nil {
+		return resp.Encode(err, false)
+	}
+	if rs == nil {
+		rs = []string{}
+	}
+
+	return resp.Encode(rs, false)
+}
+
+// parseScanArgs parses cursor [MATCH pattern] [COUNT count] of the *SCAN commands.
+func parseScanArgs(args []string) (uint64, string, int, error) {
+	cursor, err := strconv.ParseUint(args[0], 10, 64)
+	if err != nil {
+		return 0, "", 0, config.ErrInvalidCursor
+	}
+
+	pattern, count := "", 10
+	for i := 1; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return 0, "", 0, config.ErrSyntaxError
+		}
+		switch strings.ToUpper(args[i]) {
+		case "MATCH":
+			pattern = args[i+1]
+			if pattern == "*" {
+				pattern = ""
+			}
+		case "COUNT":
+			count, err = strconv.Atoi(args[i+1])
+			if err != nil {
+				return 0, "", 0, config.ErrValueNotIntegerOrOutOfRange
+			}
+			if count < 1 {
+				return 0, "", 0, config.ErrSyntaxError
+			}
+		default:
+			return 0, "", 0, config.ErrSyntaxError
+		}
+	}
+	return cursor, pattern, count, nil
+}
+
+func (h *Worker) cmdSSCAN(args []string) []byte {
+	if len(args) < 2 {
+		return resp.Encode(config.ErrWrongNumberArguments, false)
+	}
+
+	cursor, pattern, count, err := parseScanArgs(args[1:])
+	if err != nil {
+		return resp.Encode(err, false)
+	}
+	next, members, err := h.datastore.SScan(args[0], cursor, pattern, count)
+	if err != nil {
+		return resp.Encode(err, false)
+	}
+
+	return resp.Encode([]interface{}{strconv.FormatUint(next, 10), members}, false)
+}
@@ -0,0 +1,604 @@
+package worker
+
+import (
+	"backend/internal/config"
+	"backend/internal/datastore"
+	"backend/internal/payload"
+	"backend/internal/protocol/resp"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func encodeStreamEntries(entries []datastore.StreamEntry) []interface{} {
+	res := make([]interface{}, len(entries))
+	for i, e := range entries {
+		var fields interface{}
+		if e.Fields != nil {
+			fields = e.Fields
+		}
+		res[i] = []interface{}{e.ID, fields}
+	}
+	return res
+}
+
+func encodeStreamIDs(entries []datastore.StreamEntry) []string {
+	res := make([]string, len(entries))
+	for i, e := range entries {
+		res[i] = e.ID
+	}
+	return res
+}
+
+// parseStreamTrim parses MAXLEN|MINID [=|~] threshold [LIMIT count] starting at args[i]
+// and returns the index of the first argument after it.
+func parseStreamTrim(args []string, i int) (datastore.StreamTrim, int, error) {
+	trim := datastore.StreamTrim{Strategy: strings.ToUpper(args[i])}
+	i++
+
+	approx := false
+	if i < len(args) && (args[i] == "~" || args[i] == "=") {
+		approx = args[i] == "~"
+		i++
+	}
+	if i >= len(args) {
+		return trim, i, config.ErrSyntaxError
+	}
+	if trim.Strategy == "MAXLEN" {
+		n, err := strconv.Atoi(args[i])
+		if err != nil || n < 0 {
+			return trim, i, config.ErrValueNotIntegerOrOutOfRange
+		}
+		trim.MaxLen = n
+	} else {
+		trim.MinID = args[i]
+	}
+	i++
+
+	if i+1 < len(args) && strings.EqualFold(args[i], "LIMIT") {
+		if !approx {
+			return trim, i, config.ErrStreamLimitWithoutApprox
+		}
+		n, err := strconv.Atoi(args[i+1])
+		if err != nil || n < 0 {
+			return trim, i, config.ErrValueNotIntegerOrOutOfRange
+		}
+		trim.Limit = n
+		i += 2
+	}
+	return trim, i, nil
+}
+
+func (h *Worker) cmdXADD(args []string) []byte {
+	if len(args) < 4 {
+		return resp.Encode(config.ErrWrongNumberArguments, false)
+	}
+
+	key := args[0]
+	noMkStream := false
+	var trim datastore.StreamTrim
+	i := 1
+options:
+	for i < len(args) {
+		switch strings.ToUpper(args[i]) {
+		case "NOMKSTREAM":
+			noMkStream = true
+			i++
+		case "MAXLEN", "MINID":
+			var err error
+			trim, i, err = parseStreamTrim(args, i)
+			if err != nil {
+				return resp.Encode(err, false)
+			}
+		default:
+			break options
+		}
+	}
+
+	fields := args[min(i+1, len(args)):]
+	if len(fields) == 0 || len(fields)%2 != 0 {
+		return resp.Encode(config.ErrWrongNumberArguments, false)
+	}
+
+	id, ok, err := h.datastore.XAdd(key, args[i], fields, noMkStream, trim)
+	if err != nil {
+		return resp.Encode(err, false)
+	}
+	if !ok {
+		return config.RespNil
+	}
+	h.signalKeyReady(key)
+
+	return resp.Encode(id, false)
+}
+
+func (h *Worker) cmdXLEN(args []string) []byte {
+	if len(args) != 1 {
+		return resp.Encode(config.ErrWrongNumberArguments, false)
+	}
+
+	n, err := h.datastore.XLen(args[0])
+	if err != nil {
+		return resp.Encode(err, false)
+	}
+
+	return resp.Encode(n, false)
+}
+
+func (h *Worker) cmdXRANGE(args []string) []byte {
+	return h.rangeStreamCmd(args, false)
+}
+
+func (h *Worker) cmdXREVRANGE(args []string) []byte {
+	return h.rangeStreamCmd(args, true)
+}
+
+func (h *Worker) rangeStreamCmd(args []string, rev bool) []byte {
+	if len(args) != 3 && len(args) != 5 {
+		return resp.Encode(config.ErrWrongNumberArguments, false)
+	}
+
+	key, start, end := args[0], args[1], args[2]
+	if rev {
+		start, end = end, start
+	}
+
+	count := -1
+	if len(args) == 5 {
+		if !strings.EqualFold(args[3], "COUNT") {
+			return resp.Encode(config.ErrSyntaxError, false)
+		}
+		n, err := strconv.Atoi(args[4])
+		if err != nil {
+			return resp.Encode(config.ErrValueNotIntegerOrOutOfRange, false)
+		}
+		if n <= 0 {
+			return resp.Encode([]string{}, false)
+		}
+		count = n
+	}
+
+	entries, err := h.datastore.XRange(key, start, end, count, rev)
+	if err != nil {
+		return resp.Encode(err, false)
+	}
+
+	return resp.Encode(encodeStreamEntries(entries), false)
+}
+
+func (h *Worker) cmdXDEL(args []string) []byte {
+	if len(args) < 2 {
+		return resp.Encode(config.ErrWrongNumberArguments, false)
+	}
+
+	n, err := h.datastore.XDel(args[0], args[1:])
+	if err != nil {
+		return resp.Encode(err, false)
+	}
+
+	return resp.Encode(n, false)
+}
+
+func (h *Worker) cmdXTRIM(args []string) []byte {
+	if len(args) < 3 {
+		return resp.Encode(config.ErrWrongNumberArguments, false)
+	}
+
+	strategy := strings.ToUpper(args[1])
+	if strategy != "MAXLEN" && strategy != "MINID" {
+		return resp.Encode(config.ErrSyntaxError, false)
+	}
+	trim, i, err := parseStreamTrim(args, 1)
+	if err != nil {
+		return resp.Encode(err, false)
+	}
+	if i != len(args) {
+		return resp.Encode(config.ErrSyntaxError, false)
+	}
+
+	n, err := h.datastore.XTrim(args[0], trim)
+	if err != nil {
+		return resp.Encode(err, false)
+	}
+
+	return resp.Encode(n, false)
+}
+
+// streamReadArgs holds the options shared by XREAD and XREADGROUP.
+type streamReadArgs struct {
+	count   int
+	block   bool
+	timeout time.Duration
+	noAck   bool
+	keys    []string
+	ids     []string
+}
+
+// parseStreamRead parses [COUNT n] [BLOCK ms] [NOACK] STREAMS key... id...
+// NOACK is only accepted when group is set.
+func parseStreamRead(args []string, group bool) (streamReadArgs, error) {
+	var r streamReadArgs
+	for i := 0; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "COUNT":
+			if i+1 >= len(args) {
+				return r, config.ErrSyntaxError
+			}
+			n, err := strconv.Atoi(args[i+1])
+			if err != nil {
+				return r, config.ErrValueNotIntegerOrOutOfRange
+			}
+			r.count = n
+			i++
+		case "BLOCK":
+			if i+1 >= len(args) {
+				return r, config.ErrSyntaxError
+			}
+			ms, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil {
+				return r, config.ErrTimeoutNotFloat
+			}
+			if ms < 0 {
+				return r, config.ErrTimeoutNegative
+			}
+			r.block = true
+			r.timeout = time.Duration(ms) * time.Millisecond
+			i++
+		case "NOACK":
+			if !group {
+				return r, config.ErrSyntaxError
+			}
+			r.noAck = true
+		case "STREAMS":
+			rest := args[i+1:]
+			if len(rest) == 0 || len(rest)%2 != 0 {
+				return r, config.ErrStreamUnbalanced
+			}
+			r.keys = rest[:len(rest)/2]
+			r.ids = rest[len(rest)/2:]
+			return r, nil
+		default:
+			return r, config.ErrSyntaxError
+		}
+	}
+	return r, config.ErrSyntaxError
+}
+
+func (h *Worker) cmdXREAD(task *payload.Task) []byte {
+	r, err := parseStreamRead(task.Command.Args, false)
+	if err != nil {
+		return resp.Encode(err, false)
+	}
+
+	// resolve "$" now, so that blocking waits for entries added from here on
+	after := make(map[string]string, len(r.keys))
+	for i, key := range r.keys {
+		id := r.ids[i]
+		if id == "$" {
+			if id, err = h.datastore.XLastID(key); err != nil {
+				return resp.Encode(err, false)
+			}
+		}
+		after[key] = id
+	}
+
+	var res []interface{}
+	for _, key := range r.keys {
+		entries, err := h.datastore.XRead(key, after[key], r.count)
+		if err != nil {
+			return resp.Encode(err, false)
+		}
+		if len(entries) > 0 {
+			res = append(res, []interface{}{key, encodeStreamEntries(entries)})
+		}
+	}
+	if len(res) > 0 {
+		return resp.Encode(res, false)
+	}
+	if !r.block {
+		return config.RespNilArray
+	}
+
+	serve := func(key string) ([]byte, bool) {
+		entries, err := h.datastore.XRead(key, after[key], r.count)
+		if err != nil {
+			return resp.Encode(err, false), true
+		}
+		if len(entries) == 0 {
+			return nil, false
+		}
+		return resp.Encode([]interface{}{[]interface{}{key, encodeStreamEntries(entries)}}, false), true
+	}
+	h.block(task, r.keys, r.timeout, serve, config.RespNilArray)
+	return nil
+}
+
+func (h *Worker) cmdXREADGROUP(task *payload.Task) []byte {
+	args := task.Command.Args
+	if len(args) < 6 || !strings.EqualFold(args[0], "GROUP") {
+		return resp.Encode(config.ErrSyntaxError, false)
+	}
+	group, consumer := args[1], args[2]
+	r, err := parseStreamRead(args[3:], true)
+	if err != nil {
+		return resp.Encode(err, false)
+	}
+
+	// only reads of new entries (">") can block, history is always available
+	canBlock := r.block
+	var res []interface{}
+	for i, key := range r.keys {
+		id := r.ids[i]
+		entries, err := h.datastore.XReadGroup(key, group, consumer, id, r.count, r.noAck)
+		if err != nil {
+			return resp.Encode(err, false)
+		}
+		if id != ">" {
+			canBlock = false
+		}
+		if len(entries) > 0 || id != ">" {
+			res = append(res, []interface{}{key, encodeStreamEntries(entries)})
+		}
+	}
+	if len(res) > 0 {
+		return resp.Encode(res, false)
+	}
+	if !canBlock {
+		return config.RespNilArray
+	}
+
+	serve := func(key string) ([]byte, bool) {
+		entries, err := h.datastore.XReadGroup(key, group, consumer, ">", r.count, r.noAck)
+		if err != nil {
+			return resp.Encode(err, false), true
+		}
+		if len(entries) == 0 {
+			return nil, false
+		}
+		return resp.Encode([]interface{}{[]interface{}{key, encodeStreamEntries(entries)}}, false), true
+	}
+	h.block(task, r.keys, r.timeout, serve, config.RespNilArray)
+	return nil
+}
+
+func (h *Worker) cmdXGROUP(args []string) []byte {
+	if len(args) < 1 {
+		return resp.Encode(config.ErrWrongNumberArguments, false)
+	}
+
+	switch strings.ToUpper(args[0]) {
+	case "CREATE":
+		if len(args) != 4 && len(args) != 5 {
+			return resp.Encode(config.ErrWrongNumberArguments, false)
+		}
+		mkStream := false
+		if len(args) == 5 {
+			if !strings.EqualFold(args[4], "MKSTREAM") {
+				return resp.Encode(config.ErrSyntaxError, false)
+			}
+			mkStream = true
+		}
+		if err := h.datastore.XGroupCreate(args[1], args[2], args[3], mkStream); err != nil {
+			return resp.Encode(err, false)
+		}
+		return config.RespOk
+
+	case "DESTROY":
+		if len(args) != 3 {
+			return resp.Encode(config.ErrWrongNumberArguments, false)
+		}
+		n, err := h.datastore.XGroupDestroy(args[1], args[2])
+		if err != nil {
+			return resp.Encode(err, false)
+		}
+		return resp.Encode(n, false)
+
+	case "CREATECONSUMER":
+		if len(args) != 4 {
+			return resp.Encode(config.ErrWrongNumberArguments, false)
+		}
+		n, err := h.datastore.XGroupCreateConsumer(args[1], args[2], args[3])
+		if err != nil {
+			return resp.Encode(err, false)
+		}
+		return resp.Encode(n, false)
+
+	case "DELCONSUMER":
+		if len(args) != 4 {
+			return resp.Encode(config.ErrWrongNumberArguments, false)
+		}
+		n, err := h.datastore.XGroupDelConsumer(args[1], args[2], args[3])
+		if err != nil {
+			return resp.Encode(err, false)
+		}
+		return resp.Encode(n, false)
+	}
+
+	return resp.Encode(config.ErrSyntaxError, false)
+}
+
+func (h *Worker) cmdXACK(args []string) []byte {
+	if len(args) < 3 {
+		return resp.Encode(config.ErrWrongNumberArguments, false)
+	}
+
+	n, err := h.datastore.XAck(args[0], args[1], args[2:])
+	if err != nil {
+		return resp.Encode(err, false)
+	}
+
+	return resp.Encode(n, false)
+}
+
+func (h *Worker) cmdXPENDING(args []string) []byte {
+	if len(args) < 2 {
+		return resp.Encode(config.ErrWrongNumberArguments, false)
+	}
+	key, group := args[0], args[1]
+
+	if len(args) == 2 {
+		count, minID, maxID, consumers, err := h.datastore.XPendingSummary(key, group)
+		if err != nil {
+			return resp.Encode(err, false)
+		}
+		if count == 0 {
+			return resp.Encode([]interface{}{0, nil, nil, nil}, false)
+		}
+		return resp.Encode([]interface{}{count, minID, maxID, consumers}, false)
+	}
+
+	rest := args[2:]
+	var minIdle int64
+	if strings.EqualFold(rest[0], "IDLE") {
+		if len(rest) < 2 {
+			return resp.Encode(config.ErrSyntaxError, false)
+		}
+		n, err := strconv.ParseInt(rest[1], 10, 64)
+		if err != nil {
+			return resp.Encode(config.ErrValueNotIntegerOrOutOfRange, false)
+		}
+		minIdle = n
+		rest = rest[2:]
+	}
+	if len(rest) != 3 && len(rest) != 4 {
+		return resp.Encode(config.ErrSyntaxError, false)
+	}
+	count, err := strconv.Atoi(rest[2])
+	if err != nil {
+		return resp.Encode(config.ErrValueNotIntegerOrOutOfRange, false)
+	}
+	consumer := ""
+	if len(rest) == 4 {
+		consumer = rest[3]
+	}
+
+	pending, err := h.datastore.XPendingRange(key, group, minIdle, rest[0], rest[1], count, consumer)
+	if err != nil {
+		return resp.Encode(err, false)
+	}
+
+	res := make([]interface{}, len(pending))
+	for i, p := range pending {
+		res[i] = []interface{}{p.ID, p.Consumer, p.Idle, p.DeliveryCount}
+	}
+	return resp.Encode(res, false)
+}
+
+func (h *Worker) cmdXCLAIM(args []string) []byte {
+	if len(args) < 5 {
+		return resp.Encode(config.ErrWrongNumberArguments, false)
+	}
+	key, group, consumer := args[0], args[1], args[2]
+	minIdle, err := strconv.ParseInt(args[3], 10, 64)
+	if err != nil {
+		return resp.Encode(config.ErrValueNotIntegerOrOutOfRange, false)
+	}
+
+	// IDs run until the first option keyword
+	i := 4
+	for i < len(args) && isStreamIDArg(args[i]) {
+		i++
+	}
+	ids := args[4:i]
+	if len(ids) == 0 {
+		return resp.Encode(config.ErrStreamInvalidID, false)
+	}
+
+	opts := datastore.StreamClaimOptions{Idle: -1, Time: -1, RetryCount: -1}
+	for ; i < len(args); i++ {
+		opt := strings.ToUpper(args[i])
+		switch opt {
+		case "FORCE":
+			opts.Force = true
+			continue
+		case "JUSTID":
+			opts.JustID = true
+			continue
+		}
+
+		if i+1 >= len(args) {
+			return resp.Encode(config.ErrSyntaxError, false)
+		}
+		val := args[i+1]
+		i++
+		switch opt {
+		case "LASTID":
+			opts.LastID = val
+		case "IDLE", "TIME", "RETRYCOUNT":
+			n, err := strconv.ParseInt(val, 10, 64)
+			if err != nil || n < 0 {
+				return resp.Encode(config.ErrValueNotIntegerOrOutOfRange, false)
+			}
+			switch opt {
+			case "IDLE":
+				opts.Idle = n
+			case "TIME":
+				opts.Time = n
+			default:
+				opts.RetryCount = int(n)
+			}
+		default:
+			return resp.Encode(config.ErrSyntaxError, false)
+		}
+	}
+
+	entries, err := h.datastore.XClaim(key, group, consumer, minIdle, ids, opts)
+	if err != nil {
+		return resp.Encode(err, false)
+	}
+	if opts.JustID {
+		return resp.Encode(encodeStreamIDs(entries), false)
+	}
+	return resp.Encode(encodeStreamEntries(entries), false)
+}
+
+// isStreamIDArg reports whether arg looks like an entry ID rather than an option.
+func isStreamIDArg(arg string) bool {
+	ms, _, _ := strings.Cut(arg, "-")
+	_, err := strconv.ParseUint(ms, 10, 64)
+	return err == nil
+}
+
+func (h *Worker) cmdXAUTOCLAIM(args []string) []byte {
+	if len(args) < 5 {
+		return resp.Encode(config.ErrWrongNumberArguments, false)
+	}
+	key, group, consumer, start := args[0], args[1], args[2], args[4]
+	minIdle, err := strconv.ParseInt(args[3], 10, 64)
+	if err != nil {
+		return resp.Encode(config.ErrValueNotIntegerOrOutOfRange, false)
+	}
+
+	count := 100
+	justID := false
+	for i := 5; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "COUNT":
+			if i+1 >= len(args) {
+				return resp.Encode(config.ErrSyntaxError, false)
+			}
+			n, err := strconv.Atoi(args[i+1])
+			if err != nil || n <= 0 || n > math.MaxInt/datastore.XAutoClaimAttemptsFactor {
+				return resp.Encode(config.ErrValueMustBePositive, false)
+			}
+			count = n
+			i++
+		case "JUSTID":
+			justID = true
+		default:
+			return resp.Encode(config.ErrSyntaxError, false)
+		}
+	}
+
+	next, entries, deleted, err := h.datastore.XAutoClaim(key, group, consumer, minIdle, start, count, justID)
+	if err != nil {
+		return resp.Encode(err, false)
+	}
+
+	var claimed interface{} = encodeStreamEntries(entries)
+	if justID {
+		claimed = encodeStreamIDs(entries)
+	}
+	return resp.Encode([]interface{}{next, claimed, deleted}, false)
+}
@@ -0,0 +1,276 @@
+package worker
+
+import (
+	"backend/internal/config"
+	"backend/internal/datastore"
+	"backend/internal/protocol/resp"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// formatTDigestFloat renders estimates the way the t-digest replies spell
+// them, with nan and inf for empty digests and out of range ranks.
+func formatTDigestFloat(v float64) string {
+	switch {
+	case math.IsNaN(v):
+		return "nan"
+	case math.IsInf(v, 1):
+		return "inf"
+	case math.IsInf(v, -1):
+		return "-inf"
+	}
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+func formatTDigestFloats(vs []float64) []string {
+	res := make([]string, len(vs))
+	for i, v := range vs {
+		res[i] = formatTDigestFloat(v)
+	}
+	return res
+}
+
+// parseTDigestValues parses the values of ADD, CDF, RANK and REVRANK.
+func parseTDigestValues(args []string) ([]float64, error) {
+	values := make([]float64, len(args))
+	for i, arg := range args {
+		v, err := strconv.ParseFloat(arg, 64)
+		if err != nil || math.IsNaN(v) {
+			return nil, config.ErrTDigestInvalidValue
+		}
+		values[i] = v
+	}
+	return values, nil
+}
+
+func parseTDigestRanks(args []string) ([]int, error) {
+	ranks := make([]int, len(args))
+	for i, arg := range args {
+		r, err := strconv.Atoi(arg)
+		if err != nil {
+			return nil, config.ErrValueNotIntegerOrOutOfRange
+		}
+		if r < 0 {
+			return nil, config.ErrTDigestInvalidRank
+		}
+		ranks[i] = r
+	}
+	return ranks, nil
+}
+
+func (h *Worker) cmdTDIGESTCREATE(args []string) []byte {
+	if len(args) != 1 && len(args) != 3 {
+		return resp.Encode(config.ErrWrongNumberArguments, false)
+	}
+
+	compression := int64(datastore.TDigestDefaultCompression)
+	if len(args) == 3 {
+		if !strings.EqualFold(args[1], "COMPRESSION") {
+			return resp.Encode(config.ErrSyntaxError, false)
+		}
+		c, err := strconv.ParseInt(args[2], 10, 32)
+		if err != nil || c <= 0 {
+			return resp.Encode(config.ErrTDigestInvalidCompression, false)
+		}
+		compression = c
+	}
+
+	ok, err := h.datastore.CreateTDigest(args[0], float64(compression))
+	if err != nil {
+		return resp.Encode(err, false)
+	}
+
+	if !ok {
+		return resp.Encode(config.ErrKeyAlreadyExists, false)
+	}
+
+	return config.RespOk
+}
+
+func (h *Worker) cmdTDIGESTADD(args []string) []byte {
+	if len(args) < 2 {
+		return resp.Encode(config.ErrWrongNumberArguments, false)
+	}
+
+	values, err := parseTDigestValues(args[1:])
+	if err != nil {
+		return resp.Encode(err, false)
+	}
+
+	if err := h.datastore.TDigestAdd(args[0], values); err != nil {
+		return resp.Encode(err, false)
+	}
+	return config.RespOk
+}
+
+func (h *Worker) cmdTDIGESTRESET(args []string) []byte {
+	if len(args) != 1 {
+		return resp.Encode(config.ErrWrongNumberArguments, false)
+	}
+
+	if err := h.datastore.TDigestReset(args[0]); err != nil {
+		return resp.Encode(err, false)
+	}
+	return config.RespOk
+}
+
+func (h *Worker) cmdTDIGESTQUANTILE(args []string) []byte {
+	if len(args) < 2 {
+		return resp.Encode(config.ErrWrongNumberArguments, false)
+	}
+
+	qs := make([]float64, len(args)-1)
+	for i, arg := range args[1:] {
+		q, err := strconv.ParseFloat(arg, 64)
+		if err != nil {
+			return resp.Encode(config.ErrValueNotFloat, false)
+		}
+		if q < 0 || q > 1 {
+			return resp.Encode(config.ErrTDigestInvalidQuantile, false)
+		}
+		qs[i] = q
+	}
+
+	res, err := h.datastore.TDigestQuantile(args[0], qs)
+	if err != nil {
+		return resp.Encode(err, false)
+	}
+	return resp.Encode(formatTDigestFloats(res), false)
+}
+
+func (h *Worker) cmdTDIGESTCDF(args []string) []byte {
+	if len(args) < 2 {
+		return resp.Encode(config.ErrWrongNumberArguments, false)
+	}
+
+	values, err := parseTDigestValues(args[1:])
+	if err != nil {
+		return resp.Encode(err, false)
+	}
+
+	res, err := h.datastore.TDigestCDF(args[0], values)
+	if err != nil {
+		return resp.Encode(err, false)
+	}
+	return resp.Encode(formatTDigestFloats(res), false)
+}
+
+func (h *Worker) tdigestRank(args []string, rank func(key string, values []float64) ([]int, error)) []byte {
+	if len(args) < 2 {
+		return resp.Encode(config.ErrWrongNumberArguments, false)
+	}
+
+	values, err := parseTDigestValues(args[1:])
+	if err != nil {
+		return resp.Encode(err, false)
+	}
+
+	res, err := rank(args[0], values)
+	if err != nil {
+		return resp.Encode(err, false)
+	}
+	return resp.Encode(res, false)
+}
+
+func (h *Worker) cmdTDIGESTRANK(args []string) []byte {
+	return h.tdigestRank(args, h.datastore.TDigestRank)
+}
+
+func (h *Worker) cmdTDIGESTREVRANK(args []string) []byte {
+	return h.tdigestRank(args, h.datastore.TDigestRevRank)
+}
+
+func (h *Worker) tdigestByRank(args []string, byRank func(key string, ranks []int) ([]float64, error)) []byte {
+	if len(args) < 2 {
+		return resp.Encode(config.ErrWrongNumberArguments, false)
+	}
+
+	ranks, err := parseTDigestRanks(args[1:])
+	if err != nil {
+		return resp.Encode(err, false)
+	}
+
+	res, err := byRank(args[0], ranks)
+	if err != nil {
+		return resp.Encode(err, false)
+	}
+	return resp.Encode(formatTDigestFloats(res), false)
+}
+
+func (h *Worker) cmdTDIGESTBYRANK(args []string) []byte {
+	return h.tdigestByRank(args, h.datastore.TDigestByRank)
+}
+
+func (h *Worker) cmdTDIGESTBYREVRANK(args []string) []byte {
+	return h.tdigestByRank(args, h.datastore.TDigestByRevRank)
+}
+
+func (h *Worker) cmdTDIGESTTRIMMEDMEAN(args []string) []byte {
+	if len(args) != 3 {
+		return resp.Encode(config.ErrWrongNumberArguments, false)
+	}
+
+	lo, err := strconv.ParseFloat(args[1], 64)
+	if err != nil {
+		return resp.Encode(config.ErrValueNotFloat, false)
+	}
+	hi, err := strconv.ParseFloat(args[2], 64)
+	if err != nil {
+		return resp.Encode(config.ErrValueNotFloat, false)
+	}
+	if lo < 0 || lo > 1 || hi < 0 || hi > 1 {
+		return resp.Encode(config.ErrTDigestInvalidCut, false)
+	}
+	if lo >= hi {
+		return resp.Encode(config.ErrTDigestCutOrder, false)
+	}
+
+	mean, err := h.datastore.TDigestTrimmedMean(args[0], lo, hi)
+	if err != nil {
+		return resp.Encode(err, false)
+	}
+	return resp.Encode(formatTDigestFloat(mean), false)
+}
+
+func (h *Worker) tdigestMinMax(args []string, wantMax bool) []byte {
+	if len(args) != 1 {
+		return resp.Encode(config.ErrWrongNumberArguments, false)
+	}
+
+	lo, hi, err := h.datastore.TDigestMinMax(args[0])
+	if err != nil {
+		return resp.Encode(err, false)
+	}
+	if wantMax {
+		return resp.Encode(formatTDigestFloat(hi), false)
+	}
+	return resp.Encode(formatTDigestFloat(lo), false)
+}
+
+func (h *Worker) cmdTDIGESTMIN(args []string) []byte {
+	return h.tdigestMinMax(args, false)
+}
+
+func (h *Worker) cmdTDIGESTMAX(args []string) []byte {
+	return h.tdigestMinMax(args, true)
+}
+
+func (h *Worker) cmdTDIGESTINFO(args []string) []byte {
+	if len(args) != 1 {
+		return resp.Encode(config.ErrWrongNumberArguments, false)
+	}
+
+	info, err := h.datastore.TDigestInfo(args[0])
+	if err != nil {
+		return resp.Encode(err, false)
+	}
+
+	return resp.Encode([]any{
+		"Compression", int(info.Compression),
+		"Merged nodes", info.Merged,
+		"Unmerged nodes", info.Unmerged,
+		"Merged weight", formatTDigestFloat(info.MergedWeight),
+		"Observations", int(info.Observations),
+	}, false)
+}
@@ -0,0 +1,152 @@
+package worker
+
+import (
+	"backend/internal/config"
+	"backend/internal/datastore"
+	"backend/internal/protocol/resp"
+	"strconv"
+	"strings"
+)
+
+func (h *Worker) cmdTOPKRESERVE(args []string) []byte {
+	if len(args) != 2 && len(args) != 5 {
+		return resp.Encode(config.ErrWrongNumberArguments, false)
+	}
+
+	key := args[0]
+	k, err := strconv.ParseUint(args[1], 10, 32)
+	if err != nil || k == 0 {
+		return resp.Encode(config.ErrTopKInvalidK, false)
+	}
+	width, depth, decay := uint64(8), uint64(7), 0.9
+	if len(args) == 5 {
+		if width, err = strconv.ParseUint(args[2], 10, 32); err != nil || width == 0 {
+			return resp.Encode(config.ErrTopKInvalidWidth, false)
+		}
+		if depth, err = strconv.ParseUint(args[3], 10, 32); err != nil || depth == 0 {
+			return resp.Encode(config.ErrTopKInvalidDepth, false)
+		}
+		if decay, err = strconv.ParseFloat(args[4], 64); err != nil || decay <= 0 || decay > 1 {
+			return resp.Encode(config.ErrTopKInvalidDecay, false)
+		}
+	}
+
+	ok, err := h.datastore.CreateTopK(key, uint32(k), uint32(width), uint32(depth), decay)
+	if err != nil {
+		return resp.Encode(err, false)
+	}
+
+	if !ok {
+		return resp.Encode(config.ErrKeyAlreadyExists, false)
+	}
+
+	return config.RespOk
+}
+
+func (h *Worker) topkIncrBy(key string, items []string, incrs []uint32) []byte {
+	expelled, found, err := h.datastore.TopKIncrBy(key, items, incrs)
+	if err != nil {
+		return resp.Encode(err, false)
+	}
+
+	res := make([]interface{}, len(items))
+	for i := range items {
+		if found[i] {
+			res[i] = expelled[i]
+		}
+	}
+	return resp.Encode(res, false)
+}
+
+func (h *Worker) cmdTOPKADD(args []string) []byte {
+	if len(args) < 2 {
+		return resp.Encode(config.ErrWrongNumberArguments, false)
+	}
+
+	items := args[1:]
+	incrs := make([]uint32, len(items))
+	for i := range incrs {
+		incrs[i] = 1
+	}
+	return h.topkIncrBy(args[0], items, incrs)
+}
+
+func (h *Worker) cmdTOPKINCRBY(args []string) []byte {
+	if len(args) < 3 || len(args)%2 == 0 {
+		return resp.Encode(config.ErrWrongNumberArguments, false)
+	}
+
+	n := len(args) / 2
+	items := make([]string, n)
+	incrs := make([]uint32, n)
+	for i := 0; i < n; i++ {
+		items[i] = args[1+2*i]
+		incr, err := strconv.ParseUint(args[2+2*i], 10, 32)
+		if err != nil || incr == 0 || incr > datastore.TopKMaxIncrement {
+			return resp.Encode(config.ErrTopKInvalidIncrement, false)
+		}
+		incrs[i] = uint32(incr)
+	}
+	return h.topkIncrBy(args[0], items, incrs)
+}
+
+func (h *Worker) cmdTOPKQUERY(args []string) []byte {
+	if len(args) < 2 {
+		return resp.Encode(config.ErrWrongNumberArguments, false)
+	}
+
+	found, err := h.datastore.TopKQuery(args[0], args[1:])
+	if err != nil {
+		return resp.Encode(err, false)
+	}
+
+	res := make([]int, len(found))
+	for i, ok := range found {
+		if ok {
+			res[i] = 1
+		}
+	}
+	return resp.Encode(res, false)
+}
+
+func (h *Worker) cmdTOPKLIST(args []string) []byte {
+	if len(args) != 1 && len(args) != 2 {
+		return resp.Encode(config.ErrWrongNumberArguments, false)
+	}
+	withCount := len(args) == 2
+	if withCount && !strings.EqualFold(args[1], "WITHCOUNT") {
+		return resp.Encode(config.ErrSyntaxError, false)
+	}
+
+	items, err := h.datastore.TopKList(args[0])
+	if err != nil {
+		return resp.Encode(err, false)
+	}
+
+	res := make([]interface{}, 0, len(items)*2)
+	for _, item := range items {
+		res = append(res, item.Item)
+		if withCount {
+			res = append(res, item.Count)
+		}
+	}
+	return resp.Encode(res, false)
+}
+
+func (h *Worker) cmdTOPKINFO(args []string) []byte {
+	if len(args) != 1 {
+		return resp.Encode(config.ErrWrongNumberArguments, false)
+	}
+
+	info, err := h.datastore.TopKInfo(args[0])
+	if err != nil {
+		return resp.Encode(err, false)
+	}
+
+	return resp.Encode([]any{
+		"k", info.K,
+		"width", info.Width,
+		"depth", info.Depth,
+		"decay", strconv.FormatFloat(info.Decay, 'f', -1, 64),
+	}, false)
+}
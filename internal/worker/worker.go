@@ -1,14 +1,23 @@
 package worker
 
 import (
+	"backend/internal/config"
 	"backend/internal/datastore"
 	"backend/internal/payload"
+	"time"
 )
 
 type Worker struct {
 	id        int
 	datastore *datastore.Datastore
 	TaskCh    chan *payload.Task
+	execCh    chan func()
+
+	// blocking commands state, only touched from the worker goroutine
+	unblockCh chan *payload.Task
+	blocked   map[*payload.Task]*blockedClient
+	waiters   map[string][]*blockedClient
+	readyKeys []string
 }
 
 func NewWorker(id int, bufferSize int, d *datastore.Datastore) *Worker {
@@ -16,13 +25,55 @@ func NewWorker(id int, bufferSize int, d *datastore.Datastore) *Worker {
 		id:        id,
 		datastore: d,
 		TaskCh:    make(chan *payload.Task, bufferSize),
+		execCh:    make(chan func(), bufferSize),
+		unblockCh: make(chan *payload.Task, bufferSize),
+		blocked:   make(map[*payload.Task]*blockedClient),
+		waiters:   make(map[string][]*blockedClient),
 	}
 }
 
 func (w *Worker) Start() {
-	for task := range w.TaskCh {
-		w.HandleCmd(task)
+	// the active expiration cycle runs expire.hz times a second and takes at
+	// most expire.cyclePercent of the worker's time
+	period := time.Second / time.Duration(max(config.GetInt("expire.hz"), 1))
+	budget := period * time.Duration(config.GetInt("expire.cyclePercent")) / 100
+	expireTicker := time.NewTicker(period)
+	defer expireTicker.Stop()
+
+	for {
+		select {
+		case task := <-w.TaskCh:
+			w.HandleCmd(task)
+		case task := <-w.unblockCh:
+			w.unblock(task)
+		case fn := <-w.execCh:
+			fn()
+		case <-expireTicker.C:
+			w.datastore.ActiveExpire(budget)
+		}
+	}
+}
+
+// Exec runs fn on the worker goroutine, with exclusive access to the worker's
+// datastore, and waits for it to return. It lets a coordinator read and write
+// keys owned by several workers without any locking.
+func (w *Worker) Exec(fn func(ds *datastore.Datastore)) {
+	done := make(chan struct{})
+	w.execCh <- func() {
+		fn(w.datastore)
+		close(done)
 	}
+	<-done
+}
+
+// ExecWrite is Exec for a fn that writes key: clients blocked on key get
+// served once fn returns.
+func (w *Worker) ExecWrite(key string, fn func(ds *datastore.Datastore)) {
+	w.Exec(func(ds *datastore.Datastore) {
+		fn(ds)
+		w.signalKeyReady(key)
+		w.serveReadyKeys()
+	})
 }
 
 func (h *Worker) HandleCmd(task *payload.Task) {
@@ -52,6 +103,8 @@ func (h *Worker) HandleCmd(task *payload.Task) {
 		res = h.cmdExists(task.Command.Args)
 	case "DEL":
 		res = h.cmdDel(task.Command.Args)
+	case "OBJECT":
+		res = h.cmdOBJECT(task.Command.Args)
 
 	// Simple Set
 	case "SADD":
@@ -62,14 +115,56 @@ func (h *Worker) HandleCmd(task *payload.Task) {
 		res = h.cmdSIsMember(task.Command.Args)
 	case "SMISMEMBER":
 		res = h.cmdSMIsMember(task.Command.Args)
+	case "SREM":
+		res = h.cmdSREM(task.Command.Args)
+	case "SCARD":
+		res = h.cmdSCARD(task.Command.Args)
+	case "SPOP":
+		res = h.cmdSPOP(task.Command.Args)
+	case "SRANDMEMBER":
+		res = h.cmdSRANDMEMBER(task.Command.Args)
+	case "SSCAN":
+		res = h.cmdSSCAN(task.Command.Args)
+
+	// List
+	case "LPUSH":
+		res = h.cmdLPUSH(task.Command.Args)
+	case "RPUSH":
+		res = h.cmdRPUSH(task.Command.Args)
+	case "LPOP":
+		res = h.cmdLPOP(task.Command.Args)
+	case "RPOP":
+		res = h.cmdRPOP(task.Command.Args)
+	case "LLEN":
+		res = h.cmdLLEN(task.Command.Args)
+	case "LRANGE":
+		res = h.cmdLRANGE(task.Command.Args)
+	case "LMOVE":
+		res = h.cmdLMOVE(task.Command.Args)
+	case "BLPOP":
+		res = h.cmdBLPOP(task)
+	case "BRPOP":
+		res = h.cmdBRPOP(task)
+	case "BLMOVE":
+		res = h.cmdBLMOVE(task)
 
 	// Sorted Set
 	case "ZADD":
 		res = h.cmdZADD(task.Command.Args)
+	case "ZINCRBY":
+		res = h.cmdZINCRBY(task.Command.Args)
 	case "ZSCORE":
 		res = h.cmdZSCORE(task.Command.Args)
 	case "ZRANK":
 		res = h.cmdZRANK(task.Command.Args)
+	case "ZREVRANK":
+		res = h.cmdZREVRANK(task.Command.Args)
+	case "ZMSCORE":
+		res = h.cmdZMSCORE(task.Command.Args)
+	case "ZRANDMEMBER":
+		res = h.cmdZRANDMEMBER(task.Command.Args)
+	case "ZSCAN":
+		res = h.cmdZSCAN(task.Command.Args)
 	case "ZCARD":
 		res = h.cmdZCARD(task.Command.Args)
 	case "ZRANGE":
@@ -78,18 +173,157 @@ func (h *Worker) HandleCmd(task *payload.Task) {
 		res = h.cmdZREVRANGE(task.Command.Args)
 	case "ZREM":
 		res = h.cmdZREM(task.Command.Args)
+	case "ZRANGEBYSCORE":
+		res = h.cmdZRANGEBYSCORE(task.Command.Args)
+	case "ZREVRANGEBYSCORE":
+		res = h.cmdZREVRANGEBYSCORE(task.Command.Args)
+	case "ZCOUNT":
+		res = h.cmdZCOUNT(task.Command.Args)
+	case "ZRANGEBYLEX":
+		res = h.cmdZRANGEBYLEX(task.Command.Args)
+	case "ZREVRANGEBYLEX":
+		res = h.cmdZREVRANGEBYLEX(task.Command.Args)
+	case "ZLEXCOUNT":
+		res = h.cmdZLEXCOUNT(task.Command.Args)
+	case "ZREMRANGEBYLEX":
+		res = h.cmdZREMRANGEBYLEX(task.Command.Args)
+	case "ZREMRANGEBYRANK":
+		res = h.cmdZREMRANGEBYRANK(task.Command.Args)
+	case "ZREMRANGEBYSCORE":
+		res = h.cmdZREMRANGEBYSCORE(task.Command.Args)
+	case "GEOADD":
+		res = h.cmdGEOADD(task.Command.Args)
+	case "GEODIST":
+		res = h.cmdGEODIST(task.Command.Args)
+	case "GEOPOS":
+		res = h.cmdGEOPOS(task.Command.Args)
+	case "GEOHASH":
+		res = h.cmdGEOHASH(task.Command.Args)
+	case "GEOSEARCH":
+		res = h.cmdGEOSEARCH(task.Command.Args)
+	case "ZPOPMIN":
+		res = h.cmdZPOPMIN(task.Command.Args)
+	case "ZPOPMAX":
+		res = h.cmdZPOPMAX(task.Command.Args)
+	case "BZPOPMIN":
+		res = h.cmdBZPOPMIN(task)
+	case "BZPOPMAX":
+		res = h.cmdBZPOPMAX(task)
 
+	// Stream
+	case "XADD":
+		res = h.cmdXADD(task.Command.Args)
+	case "XLEN":
+		res = h.cmdXLEN(task.Command.Args)
+	case "XRANGE":
+		res = h.cmdXRANGE(task.Command.Args)
+	case "XREVRANGE":
+		res = h.cmdXREVRANGE(task.Command.Args)
+	case "XDEL":
+		res = h.cmdXDEL(task.Command.Args)
+	case "XTRIM":
+		res = h.cmdXTRIM(task.Command.Args)
+	case "XREAD":
+		res = h.cmdXREAD(task)
+	case "XGROUP":
+		res = h.cmdXGROUP(task.Command.Args)
+	case "XREADGROUP":
+		res = h.cmdXREADGROUP(task)
+	case "XACK":
+		res = h.cmdXACK(task.Command.Args)
+	case "XPENDING":
+		res = h.cmdXPENDING(task.Command.Args)
+	case "XCLAIM":
+		res = h.cmdXCLAIM(task.Command.Args)
+	case "XAUTOCLAIM":
+		res = h.cmdXAUTOCLAIM(task.Command.Args)
+
+	// HyperLogLog
+	case "PFADD":
+		res = h.cmdPFADD(task.Command.Args)
 	// CMS
 	case "CMS.INITBYDIM":
 		res = h.cmdCMSINITBYDIM(task.Command.Args)
 	case "CMS.INITBYPROB":
 		res = h.cmdCMSINITBYPROB(task.Command.Args)
+	case "CMS.INITBYWINDOW":
+		res = h.cmdCMSINITBYWINDOW(task.Command.Args)
 	case "CMS.INCRBY":
 		res = h.cmdCMSINCRBY(task.Command.Args)
 	case "CMS.QUERY":
 		res = h.cmdCMSQUERY(task.Command.Args)
 	case "CMS.INFO":
-		res = h.cmdINFO(task.Command.Args)
+		res = h.cmdCMSINFO(task.Command.Args)
+	case "CMS.RESET":
+		res = h.cmdCMSRESET(task.Command.Args)
+	// Top-K
+	case "TOPK.RESERVE":
+		res = h.cmdTOPKRESERVE(task.Command.Args)
+	case "TOPK.ADD":
+		res = h.cmdTOPKADD(task.Command.Args)
+	case "TOPK.INCRBY":
+		res = h.cmdTOPKINCRBY(task.Command.Args)
+	case "TOPK.QUERY":
+		res = h.cmdTOPKQUERY(task.Command.Args)
+	case "TOPK.LIST":
+		res = h.cmdTOPKLIST(task.Command.Args)
+	case "TOPK.INFO":
+		res = h.cmdTOPKINFO(task.Command.Args)
+	// Bloom filter
+	case "BF.RESERVE":
+		res = h.cmdBFRESERVE(task.Command.Args)
+	case "BF.ADD":
+		res = h.cmdBFADD(task.Command.Args)
+	case "BF.MADD":
+		res = h.cmdBFMADD(task.Command.Args)
+	case "BF.EXISTS":
+		res = h.cmdBFEXISTS(task.Command.Args)
+	case "BF.MEXISTS":
+		res = h.cmdBFMEXISTS(task.Command.Args)
+	case "BF.INFO":
+		res = h.cmdBFINFO(task.Command.Args)
+	// Cuckoo filter
+	case "CF.RESERVE":
+		res = h.cmdCFRESERVE(task.Command.Args)
+	case "CF.ADD":
+		res = h.cmdCFADD(task.Command.Args)
+	case "CF.ADDNX":
+		res = h.cmdCFADDNX(task.Command.Args)
+	case "CF.INSERT":
+		res = h.cmdCFINSERT(task.Command.Args)
+	case "CF.EXISTS":
+		res = h.cmdCFEXISTS(task.Command.Args)
+	case "CF.DEL":
+		res = h.cmdCFDEL(task.Command.Args)
+	case "CF.COUNT":
+		res = h.cmdCFCOUNT(task.Command.Args)
+	// t-digest
+	case "TDIGEST.CREATE":
+		res = h.cmdTDIGESTCREATE(task.Command.Args)
+	case "TDIGEST.ADD":
+		res = h.cmdTDIGESTADD(task.Command.Args)
+	case "TDIGEST.RESET":
+		res = h.cmdTDIGESTRESET(task.Command.Args)
+	case "TDIGEST.QUANTILE":
+		res = h.cmdTDIGESTQUANTILE(task.Command.Args)
+	case "TDIGEST.CDF":
+		res = h.cmdTDIGESTCDF(task.Command.Args)
+	case "TDIGEST.RANK":
+		res = h.cmdTDIGESTRANK(task.Command.Args)
+	case "TDIGEST.REVRANK":
+		res = h.cmdTDIGESTREVRANK(task.Command.Args)
+	case "TDIGEST.BYRANK":
+		res = h.cmdTDIGESTBYRANK(task.Command.Args)
+	case "TDIGEST.BYREVRANK":
+		res = h.cmdTDIGESTBYREVRANK(task.Command.Args)
+	case "TDIGEST.TRIMMED_MEAN":
+		res = h.cmdTDIGESTTRIMMEDMEAN(task.Command.Args)
+	case "TDIGEST.MIN":
+		res = h.cmdTDIGESTMIN(task.Command.Args)
+	case "TDIGEST.MAX":
+		res = h.cmdTDIGESTMAX(task.Command.Args)
+	case "TDIGEST.INFO":
+		res = h.cmdTDIGESTINFO(task.Command.Args)
 
 	default:
 		res = []byte("-CMD NOT FOUND\r\n")
@@ -97,5 +331,9 @@ func (h *Worker) HandleCmd(task *payload.Task) {
 	// _, err := syscall.Write(task.ConnFd, res)
 	// return err
 
-	task.ReplyCh <- res
+	// a nil reply means the task has been parked by a blocking command
+	if res != nil {
+		task.ReplyCh <- res
+	}
+	h.serveReadyKeys()
 }
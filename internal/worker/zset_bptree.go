@@ -2,8 +2,11 @@ package worker
 
 import (
 	"backend/internal/config"
+	"backend/internal/datastore"
+	"backend/internal/payload"
 	"backend/internal/protocol/resp"
 	"fmt"
+	"math"
 	"strconv"
 	"strings"
 )
@@ -15,19 +18,82 @@ func (h *Worker) cmdZADD(args []string) []byte {
 	key := args[0]
 	scoreIndex := 1
 
+	var opts datastore.ZAddOptions
+	incr := false
+flags:
+	for ; scoreIndex < len(args); scoreIndex++ {
+		switch strings.ToUpper(args[scoreIndex]) {
+		case "NX":
+			opts.NX = true
+		case "XX":
+			opts.XX = true
+		case "GT":
+			opts.GT = true
+		case "LT":
+			opts.LT = true
+		case "CH":
+			opts.CH = true
+		case "INCR":
+			incr = true
+		default:
+			break flags
+		}
+	}
+	if opts.NX && opts.XX {
+		return resp.Encode(config.ErrZAddXXAndNX, false)
+	}
+	if (opts.GT && opts.LT) || (opts.NX && (opts.GT || opts.LT)) {
+		return resp.Encode(config.ErrZAddGTLTNX, false)
+	}
+
 	numScoreEleArgs := len(args) - scoreIndex
 	if numScoreEleArgs%2 == 1 || numScoreEleArgs == 0 {
 		return resp.Encode(fmt.Errorf("(error) Wrong number of (score, member) arg: %d", numScoreEleArgs), false)
 	}
 
-	rs, err := h.datastore.ZADD(key, args[1:])
+	if incr {
+		if numScoreEleArgs != 2 {
+			return resp.Encode(config.ErrZAddIncrSinglePair, false)
+		}
+		return h.zincrBy(key, opts, args[scoreIndex], args[scoreIndex+1])
+	}
+
+	rs, err := h.datastore.ZADD(key, opts, args[scoreIndex:])
 	if err != nil {
 		return resp.Encode(err, false)
 	}
+	h.signalKeyReady(key)
 
 	return resp.Encode(rs, false)
 }
 
+func (h *Worker) cmdZINCRBY(args []string) []byte {
+	if len(args) != 3 {
+		return resp.Encode(config.ErrWrongNumberArguments, false)
+	}
+
+	return h.zincrBy(args[0], datastore.ZAddOptions{}, args[1], args[2])
+}
+
+// zincrBy replies with the member's new score, or nil when opts prevented the update.
+func (h *Worker) zincrBy(key string, opts datastore.ZAddOptions, incr, member string) []byte {
+	by, err := strconv.ParseFloat(incr, 64)
+	if err != nil || math.IsNaN(by) {
+		return resp.Encode(config.ErrScoreIsNotFloat, false)
+	}
+
+	score, ok, err := h.datastore.ZIncrBy(key, opts, by, member)
+	if err != nil {
+		return resp.Encode(err, false)
+	}
+	h.signalKeyReady(key)
+	if !ok {
+		return config.RespNil
+	}
+
+	return resp.Encode(strconv.FormatFloat(score, 'f', -1, 64), false)
+}
+
 func (h *Worker) cmdZSCORE(args []string) []byte {
 	if len(args) != 2 {
 		return resp.Encode(config.ErrWrongNumberArguments, false)
@@ -78,11 +144,113 @@ func (h *Worker) cmdZCARD(args []string) []byte {
 	return resp.Encode(rs, false)
 }
 
+// parseZRangeQuery parses the arguments following the key of the unified
+// ZRANGE: min max [BYSCORE|BYLEX] [REV] [LIMIT offset count] [WITHSCORES].
+func parseZRangeQuery(args []string) (datastore.ZRangeQuery, bool, error) {
+	q := datastore.ZRangeQuery{Count: -1}
+	withScores, limit := false, false
+	for i := 2; i < len(args); i++ {
+		switch {
+		case strings.EqualFold(args[i], "BYSCORE") && q.By != datastore.ZByLex:
+			q.By = datastore.ZByScore
+		case strings.EqualFold(args[i], "BYLEX") && q.By != datastore.ZByScore:
+			q.By = datastore.ZByLex
+		case strings.EqualFold(args[i], "REV"):
+			q.Rev = true
+		case strings.EqualFold(args[i], "WITHSCORES"):
+			withScores = true
+		case strings.EqualFold(args[i], "LIMIT") && i+2 < len(args):
+			var err error
+			if q.Offset, err = strconv.Atoi(args[i+1]); err != nil {
+				return q, false, config.ErrValueNotIntegerOrOutOfRange
+			}
+			if q.Count, err = strconv.Atoi(args[i+2]); err != nil {
+				return q, false, config.ErrValueNotIntegerOrOutOfRange
+			}
+			limit = true
+			i += 2
+		default:
+			return q, false, config.ErrSyntaxError
+		}
+	}
+	if limit && q.By == datastore.ZByRank {
+		return q, false, config.ErrLimitWithoutBy
+	}
+	if withScores && q.By == datastore.ZByLex {
+		return q, false, config.ErrWithScoresByLex
+	}
+
+	// with REV the score and lex bounds come as max min
+	min, max := args[0], args[1]
+	if q.Rev && q.By != datastore.ZByRank {
+		min, max = max, min
+	}
+
+	var err error
+	switch q.By {
+	case datastore.ZByScore:
+		q.Score, err = parseScoreRange(min, max)
+	case datastore.ZByLex:
+		q.Lex, err = parseLexRange(min, max)
+	default:
+		if q.Start, err = strconv.Atoi(min); err != nil {
+			return q, false, config.ErrValueNotIntegerOrOutOfRange
+		}
+		if q.Stop, err = strconv.Atoi(max); err != nil {
+			return q, false, config.ErrValueNotIntegerOrOutOfRange
+		}
+	}
+	return q, withScores, err
+}
+
+// withRangeFlags inserts flags right after the key and bounds of a range
+// command, leaving its own options to follow them.
+func withRangeFlags(args []string, flags ...string) []string {
+	if len(args) < 3 {
+		return args
+	}
+	res := make([]string, 0, len(args)+len(flags))
+	res = append(res, args[:3]...)
+	res = append(res, flags...)
+	return append(res, args[3:]...)
+}
+
 func (h *Worker) cmdZRANGE(args []string) []byte {
 	if len(args) < 3 {
 		return resp.Encode(config.ErrWrongNumberArguments, false)
 	}
 
+	q, withScores, err := parseZRangeQuery(args[1:])
+	if err != nil {
+		return resp.Encode(err, false)
+	}
+
+	res, err := h.datastore.ZQuery(args[0], q, withScores)
+	if err != nil {
+		return resp.Encode(err, false)
+	}
+
+	return resp.Encode(res, false)
+}
+
+// ParseZRangeStore parses the arguments following the keys of ZRANGESTORE,
+// which the coordinator runs.
+func ParseZRangeStore(args []string) (datastore.ZRangeQuery, error) {
+	q, withScores, err := parseZRangeQuery(args)
+	if err != nil {
+		return q, err
+	}
+	if withScores {
+		return q, config.ErrSyntaxError
+	}
+	return q, nil
+}
+
+func (h *Worker) cmdZREVRANGE(args []string) []byte {
+	if len(args) < 3 {
+		return resp.Encode(config.ErrWrongNumberArguments, false)
+	}
+
 	withScores := false
 	if len(args) >= 4 {
 		if strings.EqualFold(args[3], "WITHSCORES") {
@@ -104,9 +272,9 @@ func (h *Worker) cmdZRANGE(args []string) []byte {
 
 	var res []string
 	if withScores {
-		res, err = h.datastore.ZRangeWithScore(key, start, stop)
+		res, err = h.datastore.ZRevRangeWithScore(key, start, stop)
 	} else {
-		res, err = h.datastore.ZRange(key, start, stop)
+		res, err = h.datastore.ZRevRange(key, start, stop)
 	}
 	if err != nil {
 		return resp.Encode(err, false)
@@ -115,21 +283,229 @@ func (h *Worker) cmdZRANGE(args []string) []byte {
 	return resp.Encode(res, false)
 }
 
-func (h *Worker) cmdZREVRANGE(args []string) []byte {
-	if len(args) < 3 {
+func (h *Worker) cmdZREM(args []string) []byte {
+	if len(args) < 2 {
 		return resp.Encode(config.ErrWrongNumberArguments, false)
 	}
 
-	withScores := false
-	if len(args) >= 4 {
-		if strings.EqualFold(args[3], "WITHSCORES") {
-			withScores = true
-		} else {
-			return resp.Encode(config.ErrSyntaxError, false)
+	key := args[0]
+	members := args[1:]
+
+	rs, err := h.datastore.ZRem(key, members)
+	if err != nil {
+		return resp.Encode(err, false)
+	}
+
+	return resp.Encode(rs, false)
+}
+
+// parseScoreBound parses a ZRANGEBYSCORE bound: a float, -inf or +inf,
+// optionally prefixed by "(" to exclude it.
+func parseScoreBound(arg string) (float64, bool, error) {
+	exclusive := strings.HasPrefix(arg, "(")
+	if exclusive {
+		arg = arg[1:]
+	}
+	score, err := strconv.ParseFloat(arg, 64)
+	if err != nil || math.IsNaN(score) {
+		return 0, false, config.ErrMinMaxNotFloat
+	}
+	return score, exclusive, nil
+}
+
+func parseScoreRange(min, max string) (datastore.ZScoreRange, error) {
+	var r datastore.ZScoreRange
+	var err error
+	if r.Min, r.MinEx, err = parseScoreBound(min); err != nil {
+		return r, err
+	}
+	if r.Max, r.MaxEx, err = parseScoreBound(max); err != nil {
+		return r, err
+	}
+	return r, nil
+}
+
+// ZRANGEBYSCORE and friends are ZRANGE with the matching flags added;
+// the REV forms already take their bounds as max min.
+func (h *Worker) cmdZRANGEBYSCORE(args []string) []byte {
+	return h.cmdZRANGE(withRangeFlags(args, "BYSCORE"))
+}
+
+func (h *Worker) cmdZREVRANGEBYSCORE(args []string) []byte {
+	return h.cmdZRANGE(withRangeFlags(args, "BYSCORE", "REV"))
+}
+
+func (h *Worker) cmdZCOUNT(args []string) []byte {
+	if len(args) != 3 {
+		return resp.Encode(config.ErrWrongNumberArguments, false)
+	}
+
+	r, err := parseScoreRange(args[1], args[2])
+	if err != nil {
+		return resp.Encode(err, false)
+	}
+
+	rs, err := h.datastore.ZCount(args[0], r)
+	if err != nil {
+		return resp.Encode(err, false)
+	}
+
+	return resp.Encode(rs, false)
+}
+
+// parseLexBound parses a ZRANGEBYLEX bound: "-", "+", or a member prefixed by
+// "[" (inclusive) or "(" (exclusive).
+func parseLexBound(arg string) (datastore.ZLexBound, error) {
+	switch {
+	case arg == "-":
+		return datastore.ZLexBound{Inf: -1}, nil
+	case arg == "+":
+		return datastore.ZLexBound{Inf: 1}, nil
+	case strings.HasPrefix(arg, "["):
+		return datastore.ZLexBound{Member: arg[1:]}, nil
+	case strings.HasPrefix(arg, "("):
+		return datastore.ZLexBound{Member: arg[1:], Exclusive: true}, nil
+	}
+	return datastore.ZLexBound{}, config.ErrMinMaxNotStringRange
+}
+
+func parseLexRange(min, max string) (datastore.ZLexRange, error) {
+	var r datastore.ZLexRange
+	var err error
+	if r.Min, err = parseLexBound(min); err != nil {
+		return r, err
+	}
+	if r.Max, err = parseLexBound(max); err != nil {
+		return r, err
+	}
+	return r, nil
+}
+
+func (h *Worker) cmdZRANGEBYLEX(args []string) []byte {
+	return h.cmdZRANGE(withRangeFlags(args, "BYLEX"))
+}
+
+func (h *Worker) cmdZREVRANGEBYLEX(args []string) []byte {
+	return h.cmdZRANGE(withRangeFlags(args, "BYLEX", "REV"))
+}
+
+func (h *Worker) cmdZLEXCOUNT(args []string) []byte {
+	if len(args) != 3 {
+		return resp.Encode(config.ErrWrongNumberArguments, false)
+	}
+
+	r, err := parseLexRange(args[1], args[2])
+	if err != nil {
+		return resp.Encode(err, false)
+	}
+
+	rs, err := h.datastore.ZLexCount(args[0], r)
+	if err != nil {
+		return resp.Encode(err, false)
+	}
+
+	return resp.Encode(rs, false)
+}
+
+func (h *Worker) cmdZREMRANGEBYLEX(args []string) []byte {
+	if len(args) != 3 {
+		return resp.Encode(config.ErrWrongNumberArguments, false)
+	}
+
+	r, err := parseLexRange(args[1], args[2])
+	if err != nil {
+		return resp.Encode(err, false)
+	}
+
+	rs, err := h.datastore.ZRemRangeByLex(args[0], r)
+	if err != nil {
+		return resp.Encode(err, false)
+	}
+
+	return resp.Encode(rs, false)
+}
+
+func (h *Worker) zpop(args []string, max bool) []byte {
+	if len(args) < 1 || len(args) > 2 {
+		return resp.Encode(config.ErrWrongNumberArguments, false)
+	}
+
+	count := 1
+	if len(args) == 2 {
+		var err error
+		if count, err = strconv.Atoi(args[1]); err != nil {
+			return resp.Encode(config.ErrValueNotIntegerOrOutOfRange, false)
+		}
+		if count < 0 {
+			return resp.Encode(config.ErrValueMustBePositive, false)
 		}
 	}
 
-	key := args[0]
+	res, err := h.datastore.ZPop(args[0], count, max)
+	if err != nil {
+		return resp.Encode(err, false)
+	}
+	if res == nil {
+		res = []string{}
+	}
+
+	return resp.Encode(res, false)
+}
+
+func (h *Worker) cmdZPOPMIN(args []string) []byte {
+	return h.zpop(args, false)
+}
+
+func (h *Worker) cmdZPOPMAX(args []string) []byte {
+	return h.zpop(args, true)
+}
+
+func (h *Worker) cmdBZPOPMIN(task *payload.Task) []byte {
+	return h.blockingZPop(task, false)
+}
+
+func (h *Worker) cmdBZPOPMAX(task *payload.Task) []byte {
+	return h.blockingZPop(task, true)
+}
+
+func (h *Worker) blockingZPop(task *payload.Task, max bool) []byte {
+	args := task.Command.Args
+	if len(args) < 2 {
+		return resp.Encode(config.ErrWrongNumberArguments, false)
+	}
+
+	keys := args[:len(args)-1]
+	timeout, err := parseTimeout(args[len(args)-1])
+	if err != nil {
+		return resp.Encode(err, false)
+	}
+
+	serve := func(key string) ([]byte, bool) {
+		res, err := h.datastore.ZPop(key, 1, max)
+		if err != nil {
+			return resp.Encode(err, false), true
+		}
+		if len(res) == 0 {
+			return nil, false
+		}
+		return resp.Encode(append([]string{key}, res...), false), true
+	}
+
+	for _, key := range keys {
+		if res, ok := serve(key); ok {
+			return res
+		}
+	}
+
+	h.block(task, keys, timeout, serve, config.RespNilArray)
+	return nil
+}
+
+func (h *Worker) cmdZREMRANGEBYRANK(args []string) []byte {
+	if len(args) != 3 {
+		return resp.Encode(config.ErrWrongNumberArguments, false)
+	}
+
 	start, err := strconv.Atoi(args[1])
 	if err != nil {
 		return resp.Encode(config.ErrValueNotIntegerOrOutOfRange, false)
@@ -139,31 +515,123 @@ func (h *Worker) cmdZREVRANGE(args []string) []byte {
 		return resp.Encode(config.ErrValueNotIntegerOrOutOfRange, false)
 	}
 
-	var res []string
-	if withScores {
-		res, err = h.datastore.ZRevRangeWithScore(key, start, stop)
-	} else {
-		res, err = h.datastore.ZRevRange(key, start, stop)
+	rs, err := h.datastore.ZRemRangeByRank(args[0], start, stop)
+	if err != nil {
+		return resp.Encode(err, false)
+	}
+
+	return resp.Encode(rs, false)
+}
+
+func (h *Worker) cmdZREMRANGEBYSCORE(args []string) []byte {
+	if len(args) != 3 {
+		return resp.Encode(config.ErrWrongNumberArguments, false)
 	}
+
+	r, err := parseScoreRange(args[1], args[2])
 	if err != nil {
 		return resp.Encode(err, false)
 	}
 
-	return resp.Encode(res, false)
+	rs, err := h.datastore.ZRemRangeByScore(args[0], r)
+	if err != nil {
+		return resp.Encode(err, false)
+	}
+
+	return resp.Encode(rs, false)
 }
 
-func (h *Worker) cmdZREM(args []string) []byte {
+func (h *Worker) cmdZREVRANK(args []string) []byte {
+	if len(args) != 2 {
+		return resp.Encode(config.ErrWrongNumberArguments, false)
+	}
+
+	rank, exist, err := h.datastore.ZRevRank(args[0], args[1])
+	if err != nil {
+		return resp.Encode(err, false)
+	}
+
+	if !exist {
+		return config.RespNil
+	}
+
+	return resp.Encode(rank, false)
+}
+
+func (h *Worker) cmdZMSCORE(args []string) []byte {
 	if len(args) < 2 {
 		return resp.Encode(config.ErrWrongNumberArguments, false)
 	}
 
+	scores, found, err := h.datastore.ZMScore(args[0], args[1:])
+	if err != nil {
+		return resp.Encode(err, false)
+	}
+
+	res := make([]interface{}, len(scores))
+	for i, score := range scores {
+		if found[i] {
+			res[i] = strconv.FormatFloat(score, 'f', -1, 64)
+		}
+	}
+	return resp.Encode(res, false)
+}
+
+func (h *Worker) cmdZRANDMEMBER(args []string) []byte {
+	if len(args) < 1 || len(args) > 3 {
+		return resp.Encode(config.ErrWrongNumberArguments, false)
+	}
+
 	key := args[0]
-	members := args[1:]
+	if len(args) == 1 {
+		rs, err := h.datastore.ZRandMember(key, 1, false)
+		if err != nil {
+			return resp.Encode(err, false)
+		}
+		if len(rs) == 0 {
+			return config.RespNil
+		}
+		return resp.Encode(rs[0], false)
+	}
 
-	rs, err := h.datastore.ZRem(key, members)
+	// negative counts may repeat members and are bounded like those of
+	// SRANDMEMBER, the reply holding up to twice as many with WITHSCORES
+	count, err := strconv.Atoi(args[1])
+	if err != nil || count < -config.MaxRandCount {
+		return resp.Encode(config.ErrValueNotIntegerOrOutOfRange, false)
+	}
+	withScores := false
+	if len(args) == 3 {
+		if !strings.EqualFold(args[2], "WITHSCORES") {
+			return resp.Encode(config.ErrSyntaxError, false)
+		}
+		withScores = true
+	}
+
+	rs, err := h.datastore.ZRandMember(key, count, withScores)
 	if err != nil {
 		return resp.Encode(err, false)
 	}
+	if rs == nil {
+		rs = []string{}
+	}
 
 	return resp.Encode(rs, false)
 }
+
+func (h *Worker) cmdZSCAN(args []string) []byte {
+	if len(args) < 2 {
+		return resp.Encode(config.ErrWrongNumberArguments, false)
+	}
+
+	cursor, pattern, count, err := parseScanArgs(args[1:])
+	if err != nil {
+		return resp.Encode(err, false)
+	}
+	next, res, err := h.datastore.ZScan(args[0], cursor, pattern, count)
+	if err != nil {
+		return resp.Encode(err, false)
+	}
+
+	return resp.Encode([]interface{}{strconv.FormatUint(next, 10), res}, false)
+}